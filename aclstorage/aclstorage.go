@@ -0,0 +1,258 @@
+// Package aclstorage provides a decorator of versionedkv controlling access to keys by
+// principals, so that one storage can be shared by several parties safely.
+//
+// Every operation is checked against a policy made of allow and deny rules. A deny rule
+// takes precedence over allow rules, and an operation matching no allow rule is denied.
+//
+// Principals and keys are matched against patterns in the syntax of path.Match, except
+// that '*' matches any sequence of characters, including '/', so that a rule for "a/*"
+// covers nested keys such as "a/b/c" as well. The same syntax is used by the other
+// decorators of versionedkv taking key patterns.
+package aclstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/internal/glob"
+)
+
+// Effect is the effect of a rule.
+type Effect int
+
+const (
+	// Allow allows the operations a rule matches.
+	Allow Effect = iota + 1
+
+	// Deny denies the operations a rule matches.
+	Deny
+)
+
+// Rule describes the access of principals to keys.
+type Rule struct {
+	// Effect is the effect of the rule.
+	Effect Effect
+
+	// Principals are the patterns of principals the rule matches.
+	// The rule matches all principals if empty.
+	Principals []string
+
+	// Operations are the names of operations the rule matches, e.g.
+	// versionedkv.OperationGetValue.
+	// The rule matches all operations if empty.
+	Operations []string
+
+	// KeyPattern is the pattern of keys the rule matches.
+	// The rule matches all keys if empty; Close is matched only if empty.
+	KeyPattern string
+}
+
+// Policy is a list of rules.
+type Policy []Rule
+
+// Validate returns an error wrapping ErrBadPattern if any pattern of the policy is
+// malformed.
+func (p Policy) Validate() error {
+	for i := range p {
+		rule := &p[i]
+		patterns := rule.Principals
+		if rule.KeyPattern != "" {
+			patterns = append(patterns[:len(patterns):len(patterns)], rule.KeyPattern)
+		}
+		for _, pattern := range patterns {
+			if err := glob.Validate(pattern); err != nil {
+				return fmt.Errorf("%w; ruleIndex=%d pattern=%q", ErrBadPattern, i, pattern)
+			}
+		}
+	}
+	return nil
+}
+
+// Allows tells whether the given principal is allowed to do the given operation on the
+// given key. The key is ignored for Close. To fail closed, a malformed pattern matches
+// everything in a deny rule and nothing in an allow rule.
+func (p Policy) Allows(principal string, operation string, key string) bool {
+	allowed := false
+	for i := range p {
+		rule := &p[i]
+		if !rule.matches(principal, operation, key) {
+			continue
+		}
+		switch rule.Effect {
+		case Allow:
+			allowed = true
+		case Deny:
+			return false
+		}
+	}
+	return allowed
+}
+
+func (r *Rule) matches(principal string, operation string, key string) bool {
+	if len(r.Principals) >= 1 {
+		ok := false
+		for _, principalPattern := range r.Principals {
+			if ok = r.matchPattern(principalPattern, principal); ok {
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if len(r.Operations) >= 1 {
+		ok := false
+		for _, operation2 := range r.Operations {
+			if operation2 == operation {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	if r.KeyPattern != "" {
+		if operation == versionedkv.OperationClose {
+			return false
+		}
+		if !r.matchPattern(r.KeyPattern, key) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *Rule) matchPattern(pattern string, name string) bool {
+	ok, err := glob.Match(pattern, name)
+	if err != nil {
+		return r.Effect == Deny
+	}
+	return ok
+}
+
+// Options represents options for ACL storages.
+type Options struct {
+	// Principal returns the identity of the principal from the given context.
+	// The default value returns an empty string.
+	Principal func(ctx context.Context) (principal string)
+}
+
+func (o *Options) sanitize() {
+	if o.Principal == nil {
+		o.Principal = func(context.Context) string { return "" }
+	}
+}
+
+// New creates a new storage controlling access to the given storage with the given policy.
+// It fails if the policy is malformed (see Policy.Validate).
+//
+// Inspect is always allowed, but values the principal is not allowed to get are left out
+// of the details.
+func New(storage versionedkv.Storage, policy Policy, options Options) (versionedkv.Storage, error) {
+	if err := policy.Validate(); err != nil {
+		return nil, err
+	}
+	options.sanitize()
+	return &aclStorage{
+		storage: storage,
+		policy:  policy,
+		options: options,
+	}, nil
+}
+
+type aclStorage struct {
+	storage versionedkv.Storage
+	policy  Policy
+	options Options
+}
+
+func (as *aclStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	if err := as.check(ctx, versionedkv.OperationGetValue, key); err != nil {
+		return "", nil, err
+	}
+	return as.storage.GetValue(ctx, key)
+}
+
+func (as *aclStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	if err := as.check(ctx, versionedkv.OperationWaitForValue, key); err != nil {
+		return "", nil, err
+	}
+	return as.storage.WaitForValue(ctx, key, oldVersion)
+}
+
+func (as *aclStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	if err := as.check(ctx, versionedkv.OperationCreateValue, key); err != nil {
+		return nil, err
+	}
+	return as.storage.CreateValue(ctx, key, val)
+}
+
+func (as *aclStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := as.check(ctx, versionedkv.OperationUpdateValue, key); err != nil {
+		return nil, err
+	}
+	return as.storage.UpdateValue(ctx, key, val, oldVersion)
+}
+
+func (as *aclStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := as.check(ctx, versionedkv.OperationCreateOrUpdateValue, key); err != nil {
+		return nil, err
+	}
+	return as.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+}
+
+func (as *aclStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	if err := as.check(ctx, versionedkv.OperationDeleteValue, key); err != nil {
+		return false, err
+	}
+	return as.storage.DeleteValue(ctx, key, version)
+}
+
+func (as *aclStorage) Close() error {
+	// Close takes no context, so it is checked against the principal of a background context.
+	if err := as.check(context.Background(), versionedkv.OperationClose, ""); err != nil {
+		return err
+	}
+	return as.storage.Close()
+}
+
+func (as *aclStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	details, err := as.storage.Inspect(ctx)
+	if err != nil {
+		return versionedkv.StorageDetails{}, err
+	}
+	principal := as.options.Principal(ctx)
+	for key := range details.Values {
+		if !as.policy.Allows(principal, versionedkv.OperationGetValue, key) {
+			delete(details.Values, key)
+		}
+	}
+	if len(details.Values) == 0 {
+		details.Values = nil
+	}
+	return details, nil
+}
+
+func (as *aclStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(as.storage)
+}
+
+func (as *aclStorage) check(ctx context.Context, operation string, key string) error {
+	principal := as.options.Principal(ctx)
+	if !as.policy.Allows(principal, operation, key) {
+		return fmt.Errorf("%w; principal=%q operation=%q key=%q", ErrPermissionDenied, principal, operation, key)
+	}
+	return nil
+}
+
+// ErrBadPattern is returned when a pattern of a policy is malformed.
+var ErrBadPattern error = errors.New("aclstorage: bad pattern")
+
+// ErrPermissionDenied is returned when an operation is denied by the policy.
+var ErrPermissionDenied error = errors.New("aclstorage: permission denied")
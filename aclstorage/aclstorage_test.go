@@ -0,0 +1,128 @@
+package aclstorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	. "github.com/go-tk/versionedkv/aclstorage"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACLStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), Policy{{Effect: Allow}}, Options{})
+	})
+}
+
+type principalKey struct{}
+
+func TestACLStorage_Policy(t *testing.T) {
+	t.Parallel()
+	s, err := New(memorystorage.New(), Policy{
+		{Effect: Allow, Principals: []string{"admin"}},
+		{Effect: Allow, Principals: []string{"team-*"}, Operations: []string{versionedkv.OperationGetValue, versionedkv.OperationWaitForValue}},
+		{Effect: Allow, Principals: []string{"team-a"}, KeyPattern: "a/*"},
+		{Effect: Deny, Principals: []string{"team-*"}, KeyPattern: "*/secret"},
+	}, Options{
+		Principal: func(ctx context.Context) string {
+			principal, _ := ctx.Value(principalKey{}).(string)
+			return principal
+		},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	admin := context.WithValue(context.Background(), principalKey{}, "admin")
+	teamA := context.WithValue(context.Background(), principalKey{}, "team-a")
+	teamB := context.WithValue(context.Background(), principalKey{}, "team-b")
+
+	for _, key := range []string{"a/1", "a/secret", "b/1"} {
+		_, err := s.CreateValue(admin, key, "v")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	_, err = s.CreateValue(teamA, "a/2", "v")
+	assert.NoError(t, err)
+	_, err = s.DeleteValue(teamA, "a/2", nil)
+	assert.NoError(t, err)
+	_, err = s.CreateValue(teamB, "a/2", "v")
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	_, err = s.UpdateValue(teamA, "b/1", "v", nil)
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	_, _, err = s.GetValue(teamB, "a/1")
+	assert.NoError(t, err)
+	_, _, err = s.GetValue(teamA, "a/secret")
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+	_, _, err = s.GetValue(context.Background(), "a/1")
+	assert.True(t, errors.Is(err, ErrPermissionDenied))
+
+	details, err := s.Inspect(teamA)
+	if assert.NoError(t, err) {
+		assert.Len(t, details.Values, 2)
+		assert.Contains(t, details.Values, "a/1")
+		assert.Contains(t, details.Values, "b/1")
+	}
+	details, err = s.Inspect(context.Background())
+	if assert.NoError(t, err) {
+		assert.Nil(t, details.Values)
+	}
+
+	// Close is checked against the principal of a background context.
+	assert.True(t, errors.Is(s.Close(), ErrPermissionDenied))
+}
+
+func TestPolicy_Allows(t *testing.T) {
+	t.Parallel()
+	policy := Policy{
+		{Effect: Allow, KeyPattern: "*"},
+		{Effect: Deny, Operations: []string{versionedkv.OperationDeleteValue}},
+	}
+	assert.True(t, policy.Allows("x", versionedkv.OperationCreateValue, "k"))
+	assert.True(t, policy.Allows("x", versionedkv.OperationCreateValue, "a/k"))
+	assert.False(t, policy.Allows("x", versionedkv.OperationDeleteValue, "k"))
+	assert.False(t, policy.Allows("x", versionedkv.OperationClose, ""))
+	assert.True(t, Policy{{Effect: Allow}}.Allows("x", versionedkv.OperationClose, ""))
+}
+
+func TestPolicy_Allows_NestedKeys(t *testing.T) {
+	t.Parallel()
+	policy := Policy{
+		{Effect: Allow},
+		{Effect: Deny, KeyPattern: "secrets/*"},
+		{Effect: Deny, KeyPattern: "*/private"},
+	}
+	assert.False(t, policy.Allows("bob", versionedkv.OperationGetValue, "secrets/pw"))
+	assert.False(t, policy.Allows("bob", versionedkv.OperationGetValue, "secrets/db/pw"))
+	assert.False(t, policy.Allows("bob", versionedkv.OperationGetValue, "secrets/db/a/b/pw"))
+	assert.False(t, policy.Allows("bob", versionedkv.OperationGetValue, "a/b/private"))
+	assert.True(t, policy.Allows("bob", versionedkv.OperationGetValue, "secrets"))
+	assert.True(t, policy.Allows("bob", versionedkv.OperationGetValue, "public/db/pw"))
+	assert.True(t, policy.Allows("bob", versionedkv.OperationGetValue, "a/private/b"))
+}
+
+func TestPolicy_Validate(t *testing.T) {
+	t.Parallel()
+	for _, pattern := range []string{"secrets/[", "secrets/[]", "secrets/[a-]", "secrets/\\"} {
+		policy := Policy{{Effect: Allow}, {Effect: Deny, KeyPattern: pattern}}
+		err := policy.Validate()
+		assert.True(t, errors.Is(err, ErrBadPattern), pattern)
+		_, err = New(memorystorage.New(), policy, Options{})
+		assert.True(t, errors.Is(err, ErrBadPattern), pattern)
+		// Malformed deny rules fail closed.
+		assert.False(t, policy.Allows("bob", versionedkv.OperationGetValue, "secrets/db/pw"), pattern)
+		assert.False(t, policy.Allows("bob", versionedkv.OperationGetValue, "public"), pattern)
+	}
+	policy := Policy{{Effect: Allow, Principals: []string{"team-["}}}
+	assert.True(t, errors.Is(policy.Validate(), ErrBadPattern))
+	// Malformed allow rules fail closed.
+	assert.False(t, policy.Allows("team-[", versionedkv.OperationGetValue, "k"))
+
+	assert.NoError(t, Policy{
+		{Effect: Allow, Principals: []string{"team-?", "[^x]*", "\\*"}, KeyPattern: "a/[a-c0-9]/*"},
+	}.Validate())
+}
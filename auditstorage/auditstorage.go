@@ -0,0 +1,160 @@
+// Package auditstorage provides a decorator of versionedkv logging successful mutations
+// for auditing purposes.
+package auditstorage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Record represents a successful mutation.
+type Record struct {
+	Time      time.Time
+	Operation string
+	Key       string
+
+	// OldVersion is the version replaced or deleted by the mutation, which is known only
+	// for conditional mutations, where it is the version given as the precondition; it is
+	// nil for unconditional mutations, whose old version is unknown, and for CreateValue.
+	// CreateOrUpdateValue given an old version may still have created the value, if it
+	// did not exist.
+	OldVersion versionedkv.Version
+
+	// NewVersion is the version the mutation resulted in, nil for deletions.
+	NewVersion versionedkv.Version
+
+	// Caller is the identity of the caller taken from the context.
+	Caller string
+
+	// Value is the value written, after having been passed through the value redactor.
+	Value string
+}
+
+// Sink receives records.
+type Sink interface {
+	// WriteRecord writes the given record.
+	WriteRecord(ctx context.Context, record Record) (err error)
+}
+
+// Options represents options for audit storages.
+type Options struct {
+	// Caller returns the identity of the caller from the given context.
+	// The default value returns an empty string.
+	Caller func(ctx context.Context) (caller string)
+
+	// ValueRedactor transforms values before they are written to the sink.
+	// The default value is HashValue.
+	ValueRedactor func(key, value string) (redactedValue string)
+
+	// SinkErrorHandler is called when the sink fails to write a record. As the mutation has
+	// already been made, it does not fail the mutation.
+	// The default value ignores errors.
+	SinkErrorHandler func(record Record, err error)
+}
+
+func (o *Options) sanitize() {
+	if o.Caller == nil {
+		o.Caller = func(context.Context) string { return "" }
+	}
+	if o.ValueRedactor == nil {
+		o.ValueRedactor = HashValue
+	}
+	if o.SinkErrorHandler == nil {
+		o.SinkErrorHandler = func(Record, error) {}
+	}
+}
+
+// HashValue is a value redactor replacing values with their SHA-256 hashes, which allows
+// telling whether values changed without revealing them.
+func HashValue(_, value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// OmitValue is a value redactor omitting values.
+func OmitValue(_, _ string) string {
+	return ""
+}
+
+// KeepValue is a value redactor keeping values as is.
+func KeepValue(_, value string) string {
+	return value
+}
+
+// New creates a new storage writing records of successful mutations on the given storage
+// to the given sink.
+func New(storage versionedkv.Storage, sink Sink, options Options) versionedkv.Storage {
+	options.sanitize()
+	return &auditStorage{
+		Storage: storage,
+		sink:    sink,
+		options: options,
+	}
+}
+
+type auditStorage struct {
+	versionedkv.Storage
+
+	sink    Sink
+	options Options
+}
+
+func (as *auditStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	version, err := as.Storage.CreateValue(ctx, key, val)
+	if err == nil && version != nil {
+		as.audit(ctx, versionedkv.OperationCreateValue, key, nil, version, val)
+	}
+	return version, err
+}
+
+func (as *auditStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	newVersion, err := as.Storage.UpdateValue(ctx, key, val, oldVersion)
+	if err == nil && newVersion != nil {
+		as.audit(ctx, versionedkv.OperationUpdateValue, key, oldVersion, newVersion, val)
+	}
+	return newVersion, err
+}
+
+func (as *auditStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	newVersion, err := as.Storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+	if err == nil && newVersion != nil {
+		as.audit(ctx, versionedkv.OperationCreateOrUpdateValue, key, oldVersion, newVersion, val)
+	}
+	return newVersion, err
+}
+
+func (as *auditStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	ok, err := as.Storage.DeleteValue(ctx, key, version)
+	if err == nil && ok {
+		as.audit(ctx, versionedkv.OperationDeleteValue, key, version, nil, "")
+	}
+	return ok, err
+}
+
+func (as *auditStorage) audit(ctx context.Context, operation string, key string,
+	oldVersion versionedkv.Version, newVersion versionedkv.Version, val string) {
+	record := Record{
+		Time:       time.Now(),
+		Operation:  operation,
+		Key:        key,
+		OldVersion: oldVersion,
+		NewVersion: newVersion,
+		Caller:     as.options.Caller(ctx),
+	}
+	if operation != versionedkv.OperationDeleteValue {
+		record.Value = as.options.ValueRedactor(key, val)
+	}
+	if err := as.sink.WriteRecord(ctx, record); err != nil {
+		as.options.SinkErrorHandler(record, err)
+	}
+}
+
+func (as *auditStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(as.Storage)
+}
@@ -0,0 +1,146 @@
+package auditstorage_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	. "github.com/go-tk/versionedkv/auditstorage"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), SinkFunc(func(context.Context, Record) error { return nil }), Options{}), nil
+	})
+}
+
+type callerKey struct{}
+
+func TestAuditStorage_Records(t *testing.T) {
+	t.Parallel()
+	var records []Record
+	s := New(memorystorage.New(), SinkFunc(func(_ context.Context, record Record) error {
+		records = append(records, record)
+		return nil
+	}), Options{
+		Caller: func(ctx context.Context) string {
+			caller, _ := ctx.Value(callerKey{}).(string)
+			return caller
+		},
+	})
+	defer s.Close()
+	ctx := context.WithValue(context.Background(), callerKey{}, "alice")
+
+	v1, err := s.CreateValue(ctx, "foo", "1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.CreateValue(ctx, "foo", "1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	v2, err := s.UpdateValue(ctx, "foo", "2", v1)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.UpdateValue(ctx, "foo", "3", v1)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	v3, err := s.CreateOrUpdateValue(ctx, "foo", "3", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.DeleteValue(ctx, "bar", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.DeleteValue(ctx, "foo", v3)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	for i := range records {
+		assert.False(t, records[i].Time.IsZero())
+		records[i].Time = time.Time{}
+	}
+	assert.Equal(t, []Record{
+		{Operation: "CreateValue", Key: "foo", NewVersion: v1, Caller: "alice", Value: HashValue("foo", "1")},
+		{Operation: "UpdateValue", Key: "foo", OldVersion: v1, NewVersion: v2, Caller: "alice", Value: HashValue("foo", "2")},
+		{Operation: "CreateOrUpdateValue", Key: "foo", NewVersion: v3, Caller: "alice", Value: HashValue("foo", "3")},
+		{Operation: "DeleteValue", Key: "foo", OldVersion: v3, Caller: "alice"},
+	}, records)
+}
+
+func TestAuditStorage_SinkErrorHandler(t *testing.T) {
+	t.Parallel()
+	sinkErr := errors.New("sink broken")
+	var handledErr error
+	s := New(memorystorage.New(), SinkFunc(func(context.Context, Record) error { return sinkErr }), Options{
+		SinkErrorHandler: func(_ Record, err error) { handledErr = err },
+	})
+	defer s.Close()
+	version, err := s.CreateValue(context.Background(), "foo", "1")
+	assert.NoError(t, err)
+	assert.NotNil(t, version)
+	assert.Equal(t, sinkErr, handledErr)
+}
+
+func TestNewWriterSink(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	s := New(memorystorage.New(), NewWriterSink(&buf), Options{ValueRedactor: KeepValue})
+	defer s.Close()
+	version, err := s.CreateValue(context.Background(), "foo", "secret")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if !assert.Len(t, lines, 1) {
+		t.FailNow()
+	}
+	var record map[string]interface{}
+	err = json.Unmarshal([]byte(lines[0]), &record)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	delete(record, "time")
+	assert.Equal(t, map[string]interface{}{
+		"operation":   "CreateValue",
+		"key":         "foo",
+		"new_version": fmt.Sprint(version),
+		"value":       "secret",
+	}, record)
+}
+
+func TestNewStorageSink(t *testing.T) {
+	t.Parallel()
+	auditLog := memorystorage.New()
+	defer auditLog.Close()
+	s := New(memorystorage.New(), NewStorageSink(auditLog, "audit/"), Options{ValueRedactor: OmitValue})
+	defer s.Close()
+	for i := 0; i < 3; i++ {
+		_, err := s.CreateOrUpdateValue(context.Background(), "foo", "1", nil)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	details, err := auditLog.Inspect(context.Background())
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Len(t, details.Values, 3)
+	for key, value := range details.Values {
+		assert.True(t, strings.HasPrefix(key, "audit/"))
+		assert.Contains(t, value.V, `"operation":"CreateOrUpdateValue"`)
+		assert.NotContains(t, value.V, `"value"`)
+	}
+}
@@ -0,0 +1,108 @@
+package auditstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// SinkFunc is an adapter to allow the use of ordinary functions as sinks.
+type SinkFunc func(ctx context.Context, record Record) (err error)
+
+var _ Sink = SinkFunc(nil)
+
+// WriteRecord implements Sink.WriteRecord.
+func (sf SinkFunc) WriteRecord(ctx context.Context, record Record) error {
+	return sf(ctx, record)
+}
+
+// NewWriterSink creates a new sink writing records to the given writer, e.g. a file, as
+// JSON lines.
+func NewWriterSink(w io.Writer) Sink {
+	return &writerSink{w: w}
+}
+
+type writerSink struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (ws *writerSink) WriteRecord(_ context.Context, record Record) error {
+	data, err := marshalRecord(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	_, err = ws.w.Write(data)
+	return err
+}
+
+// NewStorageSink creates a new sink writing records to the given storage as JSON values.
+// Each record is written to a new key consisting of the given key prefix, the time of the
+// record and a sequence number, so keys sort in the order of records.
+func NewStorageSink(storage versionedkv.Storage, keyPrefix string) Sink {
+	return &storageSink{
+		storage:   storage,
+		keyPrefix: keyPrefix,
+	}
+}
+
+type storageSink struct {
+	storage   versionedkv.Storage
+	keyPrefix string
+	lastSeq   uint64
+}
+
+func (ss *storageSink) WriteRecord(ctx context.Context, record Record) error {
+	data, err := marshalRecord(record)
+	if err != nil {
+		return err
+	}
+	seq := atomic.AddUint64(&ss.lastSeq, 1)
+	key := fmt.Sprintf("%s%s-%020d", ss.keyPrefix, record.Time.UTC().Format("20060102T150405.000000000Z"), seq)
+	version, err := ss.storage.CreateValue(ctx, key, string(data))
+	if err != nil {
+		return err
+	}
+	if version == nil {
+		return fmt.Errorf("auditstorage: key already exists; key=%q", key)
+	}
+	return nil
+}
+
+type jsonRecord struct {
+	Time       time.Time `json:"time"`
+	Operation  string    `json:"operation"`
+	Key        string    `json:"key"`
+	OldVersion string    `json:"old_version,omitempty"`
+	NewVersion string    `json:"new_version,omitempty"`
+	Caller     string    `json:"caller,omitempty"`
+	Value      string    `json:"value,omitempty"`
+}
+
+func marshalRecord(record Record) ([]byte, error) {
+	return json.Marshal(jsonRecord{
+		Time:       record.Time,
+		Operation:  record.Operation,
+		Key:        record.Key,
+		OldVersion: formatVersion(record.OldVersion),
+		NewVersion: formatVersion(record.NewVersion),
+		Caller:     record.Caller,
+		Value:      record.Value,
+	})
+}
+
+func formatVersion(version versionedkv.Version) string {
+	if version == nil {
+		return ""
+	}
+	return fmt.Sprint(version)
+}
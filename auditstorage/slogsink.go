@@ -0,0 +1,43 @@
+//go:build go1.21
+// +build go1.21
+
+package auditstorage
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogSink creates a new sink writing records to the given slog handler at the info level.
+func NewSlogSink(handler slog.Handler) Sink {
+	return &slogSink{handler: handler}
+}
+
+type slogSink struct {
+	handler slog.Handler
+}
+
+func (ss *slogSink) WriteRecord(ctx context.Context, record Record) error {
+	if !ss.handler.Enabled(ctx, slog.LevelInfo) {
+		return nil
+	}
+	attrs := []slog.Attr{
+		slog.String("operation", record.Operation),
+		slog.String("key", record.Key),
+	}
+	if record.OldVersion != nil {
+		attrs = append(attrs, slog.String("old_version", formatVersion(record.OldVersion)))
+	}
+	if record.NewVersion != nil {
+		attrs = append(attrs, slog.String("new_version", formatVersion(record.NewVersion)))
+	}
+	if record.Caller != "" {
+		attrs = append(attrs, slog.String("caller", record.Caller))
+	}
+	if record.Value != "" {
+		attrs = append(attrs, slog.String("value", record.Value))
+	}
+	r := slog.NewRecord(record.Time, slog.LevelInfo, "versionedkv mutation", 0)
+	r.AddAttrs(attrs...)
+	return ss.handler.Handle(ctx, r)
+}
@@ -0,0 +1,35 @@
+//go:build go1.21
+// +build go1.21
+
+package auditstorage_test
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	. "github.com/go-tk/versionedkv/auditstorage"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSlogSink(t *testing.T) {
+	t.Parallel()
+	var buf bytes.Buffer
+	handler := slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		ReplaceAttr: func(_ []string, attr slog.Attr) slog.Attr {
+			if attr.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return attr
+		},
+	})
+	s := New(memorystorage.New(), NewSlogSink(handler), Options{ValueRedactor: KeepValue})
+	defer s.Close()
+	_, err := s.CreateValue(context.Background(), "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Regexp(t, `^level=INFO msg="versionedkv mutation" operation=CreateValue key=foo new_version=\d+ value=bar\n$`, buf.String())
+}
@@ -0,0 +1,64 @@
+// Package barrier provides distributed barriers on top of versionedkv.
+package barrier
+
+import (
+	"context"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for barriers.
+type Options struct {
+	// KeyPrefix is prepended to barrier names to form keys.
+	// The default value is "barrier/".
+	KeyPrefix string
+}
+
+func (o *Options) sanitize() {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "barrier/"
+	}
+}
+
+// Barrier blocks waiters as long as it is held. The barrier is held while its key exists,
+// and releasing the barrier deletes the key, which releases all waiters.
+type Barrier struct {
+	storage versionedkv.Storage
+	key     string
+}
+
+// New creates a new barrier with the given name on the given storage.
+func New(storage versionedkv.Storage, name string, options Options) *Barrier {
+	options.sanitize()
+	return &Barrier{
+		storage: storage,
+		key:     options.KeyPrefix + name,
+	}
+}
+
+// Hold holds the barrier. Holding a barrier already held is a no-op.
+func (b *Barrier) Hold(ctx context.Context) error {
+	_, err := b.storage.CreateValue(ctx, b.key, "")
+	return err
+}
+
+// Release releases the barrier. Releasing a barrier not held is a no-op.
+func (b *Barrier) Release(ctx context.Context) error {
+	_, err := b.storage.DeleteValue(ctx, b.key, nil)
+	return err
+}
+
+// Wait blocks until the barrier is not held or the given context is done.
+func (b *Barrier) Wait(ctx context.Context) error {
+	_, version, err := b.storage.GetValue(ctx, b.key)
+	if err != nil {
+		return err
+	}
+	for version != nil {
+		_, version, err = b.storage.WaitForValue(ctx, b.key, version)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package barrier_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/go-tk/versionedkv/barrier"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBarrier(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	b := New(s, "foo", Options{})
+	ctx := context.Background()
+
+	err := b.Wait(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	err = b.Hold(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	err = b.Hold(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var released int32
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := New(s, "foo", Options{}).Wait(ctx)
+			assert.NoError(t, err)
+			assert.Equal(t, int32(1), atomic.LoadInt32(&released))
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	atomic.StoreInt32(&released, 1)
+	err = b.Release(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	wg.Wait()
+
+	ctx2, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = b.Hold(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	err = b.Wait(ctx2)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestDoubleBarrier(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	const (
+		numberOfParticipants = 4
+		numberOfRounds       = 3
+	)
+	var entered, left int32
+	var wg sync.WaitGroup
+	for i := 0; i < numberOfParticipants; i++ {
+		db := NewDoubleBarrier(s, "foo", numberOfParticipants, fmt.Sprintf("p%d", i), Options{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx := context.Background()
+			for j := 1; j <= numberOfRounds; j++ {
+				atomic.AddInt32(&entered, 1)
+				if !assert.NoError(t, db.Enter(ctx)) {
+					return
+				}
+				assert.Equal(t, int32(j*numberOfParticipants), atomic.LoadInt32(&entered))
+				atomic.AddInt32(&left, 1)
+				if !assert.NoError(t, db.Leave(ctx)) {
+					return
+				}
+				assert.Equal(t, int32(j*numberOfParticipants), atomic.LoadInt32(&left))
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestDoubleBarrier_Leave(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	db := NewDoubleBarrier(s, "foo", 2, "p1", Options{})
+	err := db.Leave(context.Background())
+	assert.True(t, errors.Is(err, ErrNotEntered))
+}
@@ -0,0 +1,186 @@
+package barrier
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// DoubleBarrier makes a fixed number of participants enter and leave a computation together:
+// Enter blocks until all participants have entered, and Leave blocks until all participants
+// have left. A double barrier can be used for any number of rounds.
+type DoubleBarrier struct {
+	storage              versionedkv.Storage
+	key                  string
+	numberOfParticipants int
+	participantID        string
+	generation           int64
+}
+
+// NewDoubleBarrier creates a new double barrier with the given name for the given number of
+// participants on the given storage. The given participant id must be unique among the
+// participants.
+func NewDoubleBarrier(storage versionedkv.Storage, name string, numberOfParticipants int,
+	participantID string, options Options) *DoubleBarrier {
+	options.sanitize()
+	return &DoubleBarrier{
+		storage:              storage,
+		key:                  options.KeyPrefix + name,
+		numberOfParticipants: numberOfParticipants,
+		participantID:        participantID,
+	}
+}
+
+// Enter enters the double barrier and blocks until all participants have entered or the
+// given context is done. If participants of the previous round are still leaving, it waits
+// for them to leave first.
+func (db *DoubleBarrier) Enter(ctx context.Context) error {
+	val, version, err := db.storage.GetValue(ctx, db.key)
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := decodeDoubleBarrierRecord(val, version)
+		if err != nil {
+			return err
+		}
+		if record.HasParticipant(db.participantID) {
+			if record.IsFull {
+				db.generation = record.Generation
+				return nil
+			}
+		} else if !record.IsFull {
+			record.Participants = append(record.Participants, db.participantID)
+			record.IsFull = len(record.Participants) >= db.numberOfParticipants
+			newVal, err := record.Encode()
+			if err != nil {
+				return err
+			}
+			newVersion, err := db.save(ctx, newVal, version)
+			if err != nil {
+				return err
+			}
+			if newVersion != nil {
+				val, version = newVal, newVersion
+				continue
+			}
+			val, version, err = db.storage.GetValue(ctx, db.key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		// Either waiting for others to enter or for the previous round to finish.
+		val, version, err = db.storage.WaitForValue(ctx, db.key, version)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Leave leaves the double barrier and blocks until all participants have left or the given
+// context is done.
+func (db *DoubleBarrier) Leave(ctx context.Context) error {
+	val, version, err := db.storage.GetValue(ctx, db.key)
+	if err != nil {
+		return err
+	}
+	for {
+		record, err := decodeDoubleBarrierRecord(val, version)
+		if err != nil {
+			return err
+		}
+		if record.Generation != db.generation {
+			return nil
+		}
+		if record.HasParticipant(db.participantID) {
+			if !record.IsFull {
+				return fmt.Errorf("%w; participantID=%q", ErrNotEntered, db.participantID)
+			}
+			record.RemoveParticipant(db.participantID)
+			if len(record.Participants) == 0 {
+				record.Generation++
+				record.IsFull = false
+			}
+			newVal, err := record.Encode()
+			if err != nil {
+				return err
+			}
+			newVersion, err := db.save(ctx, newVal, version)
+			if err != nil {
+				return err
+			}
+			if newVersion != nil {
+				val, version = newVal, newVersion
+				continue
+			}
+			val, version, err = db.storage.GetValue(ctx, db.key)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !record.IsFull {
+			return fmt.Errorf("%w; participantID=%q", ErrNotEntered, db.participantID)
+		}
+		val, version, err = db.storage.WaitForValue(ctx, db.key, version)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (db *DoubleBarrier) save(ctx context.Context, val string, version versionedkv.Version) (versionedkv.Version, error) {
+	if version == nil {
+		return db.storage.CreateValue(ctx, db.key, val)
+	}
+	return db.storage.UpdateValue(ctx, db.key, val, version)
+}
+
+// ErrNotEntered is returned when leaving a double barrier which has not been entered.
+var ErrNotEntered error = errors.New("barrier: not entered")
+
+type doubleBarrierRecord struct {
+	Generation   int64    `json:"generation"`
+	Participants []string `json:"participants,omitempty"`
+	IsFull       bool     `json:"is_full,omitempty"`
+}
+
+func decodeDoubleBarrierRecord(val string, version versionedkv.Version) (doubleBarrierRecord, error) {
+	var record doubleBarrierRecord
+	if version != nil {
+		if err := json.Unmarshal([]byte(val), &record); err != nil {
+			return record, fmt.Errorf("barrier: malformed record: %w", err)
+		}
+	}
+	return record, nil
+}
+
+func (r *doubleBarrierRecord) Encode() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (r *doubleBarrierRecord) HasParticipant(participantID string) bool {
+	for _, participantID2 := range r.Participants {
+		if participantID2 == participantID {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *doubleBarrierRecord) RemoveParticipant(participantID string) {
+	for i, participantID2 := range r.Participants {
+		if participantID2 == participantID {
+			r.Participants = append(r.Participants[:i], r.Participants[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package versionedkv
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// DoBenchmarkStorage benchmarks storages created by the given storage factory.
+func DoBenchmarkStorage(b *testing.B, sf StorageFactory) {
+	b.Run("GetValue", func(b *testing.B) {
+		DoBenchmarkStorageGetValue(b, sf)
+	})
+	b.Run("CASContention", func(b *testing.B) {
+		DoBenchmarkStorageCASContention(b, sf)
+	})
+	b.Run("WatcherFanOut", func(b *testing.B) {
+		DoBenchmarkStorageWatcherFanOut(b, sf)
+	})
+	b.Run("KeyScaling", func(b *testing.B) {
+		DoBenchmarkStorageKeyScaling(b, sf)
+	})
+	b.Run("CreateDeleteChurn", func(b *testing.B) {
+		DoBenchmarkStorageCreateDeleteChurn(b, sf)
+	})
+}
+
+// DoBenchmarkStorageGetValue benchmarks the throughput of GetValue on a single key from
+// parallel goroutines, on storages created by the given storage factory.
+func DoBenchmarkStorageGetValue(b *testing.B, sf StorageFactory) {
+	s := newStorageForBenchmark(b, sf)
+	defer s.Close()
+	ctx := context.Background()
+	_, err := s.CreateValue(ctx, "foo", "bar")
+	if !assert.NoError(b, err) {
+		b.FailNow()
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if _, _, err := s.GetValue(ctx, "foo"); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// DoBenchmarkStorageCASContention benchmarks compare-and-swap increments of a single
+// counter by varying numbers of goroutines, on storages created by the given storage
+// factory. An operation is a successful increment; the failed attempts are reported as
+// conflicts/op.
+func DoBenchmarkStorageCASContention(b *testing.B, sf StorageFactory) {
+	for _, numberOfGoroutines := range []int{1, 4, 16, 64} {
+		numberOfGoroutines := numberOfGoroutines
+		b.Run(fmt.Sprintf("Goroutines=%d", numberOfGoroutines), func(b *testing.B) {
+			s := newStorageForBenchmark(b, sf)
+			defer s.Close()
+			ctx := context.Background()
+			_, err := s.CreateValue(ctx, "counter", "0")
+			if !assert.NoError(b, err) {
+				b.FailNow()
+			}
+			var numberOfIncrements, numberOfConflicts int64
+			increment := func() bool {
+				for {
+					value, version, err := s.GetValue(ctx, "counter")
+					if err != nil {
+						b.Error(err)
+						return false
+					}
+					n, err := strconv.Atoi(value)
+					if err != nil {
+						b.Error(err)
+						return false
+					}
+					newVersion, err := s.UpdateValue(ctx, "counter", strconv.Itoa(n+1), version)
+					if err != nil {
+						b.Error(err)
+						return false
+					}
+					if newVersion != nil {
+						return true
+					}
+					atomic.AddInt64(&numberOfConflicts, 1)
+				}
+			}
+			b.ResetTimer()
+			var wg sync.WaitGroup
+			for i := 0; i < numberOfGoroutines; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for atomic.AddInt64(&numberOfIncrements, 1) <= int64(b.N) {
+						if !increment() {
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			b.StopTimer()
+			b.ReportMetric(float64(numberOfConflicts)/float64(b.N), "conflicts/op")
+			value, _, err := s.GetValue(ctx, "counter")
+			assert.NoError(b, err)
+			assert.Equal(b, strconv.Itoa(b.N), value)
+		})
+	}
+}
+
+// DoBenchmarkStorageWatcherFanOut benchmarks the propagation of updates by one writer to
+// varying numbers of goroutines waiting for the value, on storages created by the given
+// storage factory. An operation is an update observed by all the waiters.
+func DoBenchmarkStorageWatcherFanOut(b *testing.B, sf StorageFactory) {
+	for _, numberOfWaiters := range []int{1, 10, 100} {
+		numberOfWaiters := numberOfWaiters
+		b.Run(fmt.Sprintf("Waiters=%d", numberOfWaiters), func(b *testing.B) {
+			s := newStorageForBenchmark(b, sf)
+			defer s.Close()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			version, err := s.CreateValue(ctx, "foo", "0")
+			if !assert.NoError(b, err) {
+				b.FailNow()
+			}
+			var observations sync.WaitGroup
+			var waiters sync.WaitGroup
+			for i := 0; i < numberOfWaiters; i++ {
+				waiters.Add(1)
+				go func(version Version) {
+					defer waiters.Done()
+					for {
+						_, newVersion, err := s.WaitForValue(ctx, "foo", version)
+						if err != nil {
+							if ctx.Err() == nil {
+								b.Error(err)
+							}
+							return
+						}
+						version = newVersion
+						observations.Done()
+					}
+				}(version)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				observations.Add(numberOfWaiters)
+				version, err = s.UpdateValue(ctx, "foo", strconv.Itoa(i+1), version)
+				if !assert.NoError(b, err) || !assert.NotNil(b, version) {
+					break
+				}
+				observations.Wait()
+			}
+			b.StopTimer()
+			cancel()
+			waiters.Wait()
+		})
+	}
+}
+
+// DoBenchmarkStorageKeyScaling benchmarks GetValue on random keys from parallel
+// goroutines with varying numbers of keys, on storages created by the given storage
+// factory.
+func DoBenchmarkStorageKeyScaling(b *testing.B, sf StorageFactory) {
+	for _, numberOfKeys := range []int{10, 1000, 10000} {
+		numberOfKeys := numberOfKeys
+		b.Run(fmt.Sprintf("Keys=%d", numberOfKeys), func(b *testing.B) {
+			s := newStorageForBenchmark(b, sf)
+			defer s.Close()
+			ctx := context.Background()
+			for i := 0; i < numberOfKeys; i++ {
+				_, err := s.CreateValue(ctx, "key"+strconv.Itoa(i), "value")
+				if !assert.NoError(b, err) {
+					b.FailNow()
+				}
+			}
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				random := rand.New(rand.NewSource(rand.Int63()))
+				for pb.Next() {
+					if _, _, err := s.GetValue(ctx, "key"+strconv.Itoa(random.Intn(numberOfKeys))); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
+
+// DoBenchmarkStorageCreateDeleteChurn benchmarks creating and then deleting short-lived
+// values from parallel goroutines, on storages created by the given storage factory.
+// An operation is a creation plus a deletion.
+func DoBenchmarkStorageCreateDeleteChurn(b *testing.B, sf StorageFactory) {
+	s := newStorageForBenchmark(b, sf)
+	defer s.Close()
+	ctx := context.Background()
+	var keyID int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			key := "key" + strconv.FormatInt(atomic.AddInt64(&keyID, 1), 10)
+			version, err := s.CreateValue(ctx, key, "value")
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			if _, err := s.DeleteValue(ctx, key, version); err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+func newStorageForBenchmark(b *testing.B, sf StorageFactory) Storage {
+	s, err := sf()
+	if !assert.NoError(b, err) {
+		b.FailNow()
+	}
+	return s
+}
@@ -0,0 +1,71 @@
+package versionedkv
+
+// Capability represents an optional feature of a storage, which goes beyond Storage.
+//
+// Capabilities are identified by names, so that storages can advertise capabilities
+// unknown to this package, e.g. ones of extensions which are not part of Storage yet,
+// and opt in to the matching test groups of DoTestStorage as they are added.
+type Capability string
+
+const (
+	// CapabilityBinaryValues means values can be arbitrary byte sequences, including NUL
+	// bytes and invalid UTF-8, rather than only text.
+	CapabilityBinaryValues Capability = "BinaryValues"
+
+	// CapabilityWatcherCounting means Inspect reports the number of WaitForValue calls
+	// blocking as StorageDetails.NumberOfWatchers.
+	CapabilityWatcherCounting Capability = "WatcherCounting"
+
+	// CapabilityLeakChecking means Inspect reports exactly the values stored, leaving out
+	// internal entries such as placeholders for watchers, and Close stops every goroutine
+	// of the storage, so that leaks can be checked for.
+	CapabilityLeakChecking Capability = "LeakChecking"
+
+	// CapabilityLinearizability means concurrent operations are linearizable, as checked
+	// by CheckLinearizability.
+	CapabilityLinearizability Capability = "Linearizability"
+)
+
+// Capability names reserved for features which Storage has no API for yet; no test groups
+// run for them.
+const (
+	// CapabilityListing is reserved for listing keys.
+	CapabilityListing Capability = "Listing"
+
+	// CapabilityTTL is reserved for values expiring after a time to live.
+	CapabilityTTL Capability = "TTL"
+
+	// CapabilityTransactions is reserved for atomic operations on multiple keys.
+	CapabilityTransactions Capability = "Transactions"
+
+	// CapabilityHistory is reserved for retrieving past versions of values.
+	CapabilityHistory Capability = "History"
+)
+
+// CapabilityReporter is the optional interface of storages advertising capabilities.
+type CapabilityReporter interface {
+	// Capabilities returns the capabilities of the storage.
+	Capabilities() []Capability
+}
+
+// HasCapability reports whether the given storage advertises the given capability.
+// Storages not implementing CapabilityReporter have no capabilities.
+func HasCapability(storage Storage, capability Capability) bool {
+	for _, capability2 := range CapabilitiesOf(storage) {
+		if capability2 == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// CapabilitiesOf returns the capabilities the given storage advertises, for decorators
+// forwarding the capabilities of the storages they decorate.
+// Storages not implementing CapabilityReporter have no capabilities.
+func CapabilitiesOf(storage Storage) []Capability {
+	capabilityReporter, ok := storage.(CapabilityReporter)
+	if !ok {
+		return nil
+	}
+	return capabilityReporter.Capabilities()
+}
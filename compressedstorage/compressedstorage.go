@@ -0,0 +1,186 @@
+// Package compressedstorage provides a decorator of versionedkv compressing values with
+// gzip before they reach the storage decorated.
+//
+// Each value written starts with a header byte telling whether the rest is compressed, so
+// values above and below the size threshold coexist. Values without a known header byte,
+// e.g. those written before the decorator was introduced, are read as is. Versions pass
+// through untouched, so version-based concurrency control is not affected.
+//
+// Compressed values are binary, so the storage decorated must accept arbitrary bytes in
+// values.
+package compressedstorage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/go-tk/versionedkv"
+)
+
+const (
+	headerUncompressed = '\x00'
+	headerGzip         = '\x01'
+)
+
+// Options represents options for compressed storages.
+type Options struct {
+	// Threshold is the size in bytes from which values are compressed.
+	// The default value is 1024.
+	Threshold int
+
+	// Level is the gzip compression level.
+	// The default value is gzip.DefaultCompression.
+	Level int
+}
+
+func (o *Options) sanitize() {
+	if o.Threshold <= 0 {
+		o.Threshold = 1024
+	}
+	if o.Level == 0 {
+		o.Level = gzip.DefaultCompression
+	}
+}
+
+// New creates a new storage compressing values of the given storage.
+func New(storage versionedkv.Storage, options Options) versionedkv.Storage {
+	options.sanitize()
+	return &compressedStorage{
+		storage: storage,
+		options: options,
+	}
+}
+
+type compressedStorage struct {
+	storage versionedkv.Storage
+	options Options
+}
+
+func (cs *compressedStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	data, version, err := cs.storage.GetValue(ctx, key)
+	if err != nil || version == nil {
+		return "", version, err
+	}
+	val, err := decompress(key, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return val, version, nil
+}
+
+func (cs *compressedStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	data, newVersion, err := cs.storage.WaitForValue(ctx, key, oldVersion)
+	if err != nil || newVersion == nil {
+		return "", newVersion, err
+	}
+	val, err := decompress(key, data)
+	if err != nil {
+		return "", nil, err
+	}
+	return val, newVersion, nil
+}
+
+func (cs *compressedStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	data, err := cs.compress(val)
+	if err != nil {
+		return nil, err
+	}
+	return cs.storage.CreateValue(ctx, key, data)
+}
+
+func (cs *compressedStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	data, err := cs.compress(val)
+	if err != nil {
+		return nil, err
+	}
+	return cs.storage.UpdateValue(ctx, key, data, oldVersion)
+}
+
+func (cs *compressedStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	data, err := cs.compress(val)
+	if err != nil {
+		return nil, err
+	}
+	return cs.storage.CreateOrUpdateValue(ctx, key, data, oldVersion)
+}
+
+func (cs *compressedStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	return cs.storage.DeleteValue(ctx, key, version)
+}
+
+func (cs *compressedStorage) Close() error {
+	return cs.storage.Close()
+}
+
+func (cs *compressedStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	details, err := cs.storage.Inspect(ctx)
+	if err != nil {
+		return versionedkv.StorageDetails{}, err
+	}
+	for key, valueDetails := range details.Values {
+		valueDetails.V, err = decompress(key, valueDetails.V)
+		if err != nil {
+			return versionedkv.StorageDetails{}, err
+		}
+		details.Values[key] = valueDetails
+	}
+	return details, nil
+}
+
+func (cs *compressedStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(cs.storage)
+}
+
+func (cs *compressedStorage) compress(val string) (string, error) {
+	if len(val) >= cs.options.Threshold {
+		var buf bytes.Buffer
+		buf.WriteByte(headerGzip)
+		w, err := gzip.NewWriterLevel(&buf, cs.options.Level)
+		if err != nil {
+			return "", err
+		}
+		if _, err := w.Write([]byte(val)); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		if buf.Len() < 1+len(val) {
+			return buf.String(), nil
+		}
+		// Not worth it, e.g. the value has already been compressed.
+	}
+	return string(headerUncompressed) + val, nil
+}
+
+func decompress(key, data string) (string, error) {
+	if data == "" {
+		return "", nil
+	}
+	switch data[0] {
+	case headerUncompressed:
+		return data[1:], nil
+	case headerGzip:
+		r, err := gzip.NewReader(bytes.NewReader([]byte(data[1:])))
+		if err != nil {
+			return "", fmt.Errorf("%w: %v; key=%q", ErrDecompression, err, key)
+		}
+		val, err := ioutil.ReadAll(r)
+		if err != nil {
+			return "", fmt.Errorf("%w: %v; key=%q", ErrDecompression, err, key)
+		}
+		return string(val), nil
+	default:
+		return data, nil
+	}
+}
+
+// ErrDecompression is returned when a value fails to be decompressed.
+var ErrDecompression error = errors.New("compressedstorage: decompression failed")
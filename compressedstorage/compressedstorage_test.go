@@ -0,0 +1,75 @@
+package compressedstorage_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	. "github.com/go-tk/versionedkv/compressedstorage"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressedStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), Options{Threshold: 4}), nil
+	})
+}
+
+func TestCompressedStorage_Compression(t *testing.T) {
+	t.Parallel()
+	ms := memorystorage.New()
+	defer ms.Close()
+	s := New(ms, Options{Threshold: 100})
+	ctx := context.Background()
+
+	bigValue := strings.Repeat(`{"foo":"bar"},`, 100)
+	_, err := s.CreateValue(ctx, "big", bigValue)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.CreateValue(ctx, "small", "abc")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = ms.CreateValue(ctx, "legacy", "xyz")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	details, err := ms.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, byte(1), details.Values["big"].V[0])
+	assert.Less(t, len(details.Values["big"].V), len(bigValue)/5)
+	assert.Equal(t, "\x00abc", details.Values["small"].V)
+
+	for key, expectedValue := range map[string]string{
+		"big":    bigValue,
+		"small":  "abc",
+		"legacy": "xyz",
+	} {
+		val, _, err := s.GetValue(ctx, key)
+		assert.NoError(t, err)
+		assert.Equal(t, expectedValue, val)
+	}
+
+	version, err := s.UpdateValue(ctx, "small", bigValue, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	val, newVersion, err := s.WaitForValue(ctx, "small", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, version, newVersion)
+	assert.Equal(t, bigValue, val)
+
+	_, err = ms.UpdateValue(ctx, "small", "\x01garbage", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = s.GetValue(ctx, "small")
+	assert.True(t, errors.Is(err, ErrDecompression))
+}
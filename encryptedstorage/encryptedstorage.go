@@ -0,0 +1,251 @@
+// Package encryptedstorage provides a decorator of versionedkv encrypting values with
+// AES-GCM before they reach the storage decorated.
+//
+// An encrypted value has the form "enc:v1:<key id>:<base64 of nonce and ciphertext>", so
+// values encrypted with old keys can still be decrypted after the current key has been
+// rotated, as long as the old keys are kept. The key the value is stored under is bound to
+// the ciphertext as additional authenticated data, so ciphertexts cannot be moved between
+// keys. Versions pass through untouched, so version-based concurrency control is not
+// affected.
+package encryptedstorage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for encrypted storages.
+type Options struct {
+	// Keys maps key ids to AES keys, which are 16, 24 or 32 bytes long. Key ids must be
+	// non-empty and must not contain colons.
+	Keys map[string][]byte
+
+	// CurrentKeyID is the id of the key used to encrypt values.
+	CurrentKeyID string
+
+	// AllowPlaintext makes values not encrypted read as is rather than fail to be decrypted,
+	// for migrating storages with values in plaintext.
+	AllowPlaintext bool
+}
+
+// EncryptedStorage is a storage encrypting values of another storage.
+type EncryptedStorage struct {
+	storage        versionedkv.Storage
+	aeads          map[string]cipher.AEAD
+	currentKeyID   string
+	allowPlaintext bool
+}
+
+var _ versionedkv.Storage = (*EncryptedStorage)(nil)
+
+// New creates a new encrypted storage on the given storage.
+func New(storage versionedkv.Storage, options Options) (*EncryptedStorage, error) {
+	aeads := make(map[string]cipher.AEAD, len(options.Keys))
+	for keyID, key := range options.Keys {
+		if keyID == "" || strings.Contains(keyID, ":") {
+			return nil, fmt.Errorf("encryptedstorage: invalid key id; keyID=%q", keyID)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("encryptedstorage: invalid key; keyID=%q: %w", keyID, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		aeads[keyID] = aead
+	}
+	if _, ok := aeads[options.CurrentKeyID]; !ok {
+		return nil, fmt.Errorf("encryptedstorage: current key not found; keyID=%q", options.CurrentKeyID)
+	}
+	return &EncryptedStorage{
+		storage:        storage,
+		aeads:          aeads,
+		currentKeyID:   options.CurrentKeyID,
+		allowPlaintext: options.AllowPlaintext,
+	}, nil
+}
+
+// GetValue implements versionedkv.Storage.GetValue.
+func (es *EncryptedStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	ciphertext, version, err := es.storage.GetValue(ctx, key)
+	if err != nil || version == nil {
+		return "", version, err
+	}
+	val, _, err := es.decrypt(key, ciphertext)
+	if err != nil {
+		return "", nil, err
+	}
+	return val, version, nil
+}
+
+// WaitForValue implements versionedkv.Storage.WaitForValue.
+func (es *EncryptedStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	ciphertext, newVersion, err := es.storage.WaitForValue(ctx, key, oldVersion)
+	if err != nil || newVersion == nil {
+		return "", newVersion, err
+	}
+	val, _, err := es.decrypt(key, ciphertext)
+	if err != nil {
+		return "", nil, err
+	}
+	return val, newVersion, nil
+}
+
+// CreateValue implements versionedkv.Storage.CreateValue.
+func (es *EncryptedStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	ciphertext, err := es.encrypt(key, val)
+	if err != nil {
+		return nil, err
+	}
+	return es.storage.CreateValue(ctx, key, ciphertext)
+}
+
+// UpdateValue implements versionedkv.Storage.UpdateValue.
+func (es *EncryptedStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	ciphertext, err := es.encrypt(key, val)
+	if err != nil {
+		return nil, err
+	}
+	return es.storage.UpdateValue(ctx, key, ciphertext, oldVersion)
+}
+
+// CreateOrUpdateValue implements versionedkv.Storage.CreateOrUpdateValue.
+func (es *EncryptedStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	ciphertext, err := es.encrypt(key, val)
+	if err != nil {
+		return nil, err
+	}
+	return es.storage.CreateOrUpdateValue(ctx, key, ciphertext, oldVersion)
+}
+
+// DeleteValue implements versionedkv.Storage.DeleteValue.
+func (es *EncryptedStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	return es.storage.DeleteValue(ctx, key, version)
+}
+
+// Close implements versionedkv.Storage.Close.
+func (es *EncryptedStorage) Close() error {
+	return es.storage.Close()
+}
+
+// Inspect implements versionedkv.Storage.Inspect.
+func (es *EncryptedStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	details, err := es.storage.Inspect(ctx)
+	if err != nil {
+		return versionedkv.StorageDetails{}, err
+	}
+	for key, valueDetails := range details.Values {
+		if valueDetails.V == "" {
+			// Never encrypted, e.g. a placeholder for a value which does not exist.
+			continue
+		}
+		valueDetails.V, _, err = es.decrypt(key, valueDetails.V)
+		if err != nil {
+			return versionedkv.StorageDetails{}, err
+		}
+		details.Values[key] = valueDetails
+	}
+	return details, nil
+}
+
+// Capabilities implements versionedkv.CapabilityReporter.Capabilities, forwarding the
+// capabilities of the storage decorated.
+func (es *EncryptedStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(es.storage)
+}
+
+// Reencrypt re-encrypts the values for the given keys with the current key, if they have
+// been encrypted with other keys or not been encrypted at all. It returns the number of
+// values re-encrypted. Values for keys which do not exist are skipped.
+func (es *EncryptedStorage) Reencrypt(ctx context.Context, keys ...string) (int, error) {
+	var n int
+	for _, key := range keys {
+		for {
+			ciphertext, version, err := es.storage.GetValue(ctx, key)
+			if err != nil {
+				return n, err
+			}
+			if version == nil {
+				break
+			}
+			val, keyID, err := es.decrypt(key, ciphertext)
+			if err != nil {
+				return n, err
+			}
+			if keyID == es.currentKeyID {
+				break
+			}
+			ciphertext, err = es.encrypt(key, val)
+			if err != nil {
+				return n, err
+			}
+			newVersion, err := es.storage.UpdateValue(ctx, key, ciphertext, version)
+			if err != nil {
+				return n, err
+			}
+			if newVersion != nil {
+				n++
+				break
+			}
+			// The value has been changed in between, start over.
+		}
+	}
+	return n, nil
+}
+
+const prefix = "enc:v1:"
+
+func (es *EncryptedStorage) encrypt(key, val string) (string, error) {
+	aead := es.aeads[es.currentKeyID]
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(val)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	data := aead.Seal(nonce, nonce, []byte(val), []byte(key))
+	return prefix + es.currentKeyID + ":" + base64.RawStdEncoding.EncodeToString(data), nil
+}
+
+func (es *EncryptedStorage) decrypt(key, ciphertext string) (string, string, error) {
+	if !strings.HasPrefix(ciphertext, prefix) {
+		if es.allowPlaintext {
+			return ciphertext, "", nil
+		}
+		return "", "", fmt.Errorf("%w: value not encrypted; key=%q", ErrDecryption, key)
+	}
+	rest := ciphertext[len(prefix):]
+	i := strings.IndexByte(rest, ':')
+	if i < 0 {
+		return "", "", fmt.Errorf("%w: malformed value; key=%q", ErrDecryption, key)
+	}
+	keyID, encodedData := rest[:i], rest[i+1:]
+	aead, ok := es.aeads[keyID]
+	if !ok {
+		return "", "", fmt.Errorf("%w: key not found; key=%q keyID=%q", ErrDecryption, key, keyID)
+	}
+	data, err := base64.RawStdEncoding.DecodeString(encodedData)
+	if err != nil || len(data) < aead.NonceSize() {
+		return "", "", fmt.Errorf("%w: malformed value; key=%q", ErrDecryption, key)
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, data, []byte(key))
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v; key=%q keyID=%q", ErrDecryption, err, key, keyID)
+	}
+	return string(plaintext), keyID, nil
+}
+
+// ErrDecryption is returned when a value fails to be decrypted.
+var ErrDecryption error = errors.New("encryptedstorage: decryption failed")
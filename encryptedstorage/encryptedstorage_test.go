@@ -0,0 +1,136 @@
+package encryptedstorage_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	. "github.com/go-tk/versionedkv/encryptedstorage"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	key1 = bytes.Repeat([]byte{1}, 32)
+	key2 = bytes.Repeat([]byte{2}, 16)
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		s, err := New(memorystorage.New(), Options{
+			Keys:         map[string][]byte{"k1": key1},
+			CurrentKeyID: "k1",
+		})
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+	_, err := New(memorystorage.New(), Options{Keys: map[string][]byte{"k1": key1}, CurrentKeyID: "k2"})
+	assert.Error(t, err)
+	_, err = New(memorystorage.New(), Options{Keys: map[string][]byte{"k:1": key1}, CurrentKeyID: "k:1"})
+	assert.Error(t, err)
+	_, err = New(memorystorage.New(), Options{Keys: map[string][]byte{"k1": key1[:5]}, CurrentKeyID: "k1"})
+	assert.Error(t, err)
+}
+
+func TestEncryptedStorage_Encryption(t *testing.T) {
+	t.Parallel()
+	ms := memorystorage.New()
+	defer ms.Close()
+	s, err := New(ms, Options{Keys: map[string][]byte{"k1": key1}, CurrentKeyID: "k1"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ctx := context.Background()
+
+	version, err := s.CreateValue(ctx, "foo", "secret")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ciphertext, version2, err := ms.GetValue(ctx, "foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, version, version2)
+	assert.True(t, strings.HasPrefix(ciphertext, "enc:v1:k1:"))
+	assert.NotContains(t, ciphertext, "secret")
+
+	// Ciphertexts are bound to keys.
+	_, err = ms.CreateValue(ctx, "bar", ciphertext)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = s.GetValue(ctx, "bar")
+	assert.True(t, errors.Is(err, ErrDecryption))
+
+	// Plaintext values are rejected unless allowed.
+	_, err = ms.CreateOrUpdateValue(ctx, "bar", "plain", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = s.GetValue(ctx, "bar")
+	assert.True(t, errors.Is(err, ErrDecryption))
+}
+
+func TestEncryptedStorage_Reencrypt(t *testing.T) {
+	t.Parallel()
+	ms := memorystorage.New()
+	defer ms.Close()
+	ctx := context.Background()
+	_, err := ms.CreateValue(ctx, "plain", "p")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s1, err := New(ms, Options{Keys: map[string][]byte{"k1": key1}, CurrentKeyID: "k1"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s1.CreateValue(ctx, "foo", "f")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	s2, err := New(ms, Options{
+		Keys:           map[string][]byte{"k1": key1, "k2": key2},
+		CurrentKeyID:   "k2",
+		AllowPlaintext: true,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	val, _, err := s2.GetValue(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "f", val)
+	n, err := s2.Reencrypt(ctx, "plain", "foo", "none")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, n)
+	n, err = s2.Reencrypt(ctx, "plain", "foo", "none")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	details, err := ms.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, key := range []string{"plain", "foo"} {
+		assert.True(t, strings.HasPrefix(details.Values[key].V, "enc:v1:k2:"))
+	}
+	details, err = s2.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "p", details.Values["plain"].V)
+	assert.Equal(t, "f", details.Values["foo"].V)
+
+	// Values re-encrypted can no longer be decrypted without the new key.
+	_, _, err = s1.GetValue(ctx, "foo")
+	assert.True(t, errors.Is(err, ErrDecryption))
+}
@@ -0,0 +1,268 @@
+// Package faultstorage provides a decorator of versionedkv injecting faults, for chaos
+// testing of code built on storages.
+//
+// Faults are described by rules matching operations and keys. Whether a matching rule
+// fires is decided by a random number generator with the given seed, so a run can be
+// replayed as long as operations are issued in the same order.
+package faultstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/internal/glob"
+)
+
+// Rule describes a fault and the operations it applies to.
+type Rule struct {
+	// Operations are the names of operations the rule matches, e.g.
+	// versionedkv.OperationGetValue.
+	// The rule matches all operations if empty.
+	Operations []string
+
+	// KeyPattern is the pattern of keys the rule matches, in the syntax of path.Match,
+	// except that '*' matches any sequence of characters, including '/', as in aclstorage.
+	// The rule matches all keys if empty; Inspect is matched only if empty.
+	KeyPattern string
+
+	// Probability is the probability the rule fires on a matching operation.
+	// The rule always fires if 0.
+	Probability float64
+
+	// MaxHits is the maximum number of times the rule fires.
+	// There is no limit if 0.
+	MaxHits int
+
+	// Latency is the delay injected before the operation.
+	Latency time.Duration
+
+	// Err is the error the operation fails with, without reaching the storage decorated.
+	Err error
+
+	// Closed makes the operation fail with versionedkv.ErrStorageClosed, without reaching
+	// the storage decorated, as if the storage had been closed.
+	Closed bool
+
+	// DropNotification makes WaitForValue ignore the first change it observes and go on
+	// waiting for the next one, as if the notification had been lost.
+	DropNotification bool
+
+	// SpuriousWakeup makes WaitForValue return the current value right away, even if the
+	// current version is equal to the old version, or a nil version if the value does not
+	// exist.
+	SpuriousWakeup bool
+}
+
+// Options represents options for fault storages.
+type Options struct {
+	// Rules are the rules of faults. For each operation, only the first rule which matches
+	// and fires applies.
+	Rules []Rule
+
+	// Seed is the seed of the random number generator deciding whether rules fire.
+	Seed int64
+}
+
+// FaultStorage is a storage injecting faults into operations on another storage.
+type FaultStorage struct {
+	storage versionedkv.Storage
+
+	mu    sync.Mutex
+	rules []Rule
+	hits  []int
+	rand  *rand.Rand
+}
+
+var _ versionedkv.Storage = (*FaultStorage)(nil)
+
+// New creates a new fault storage injecting faults into operations on the given storage.
+// It fails if any rule is malformed (see SetRules).
+func New(storage versionedkv.Storage, options Options) (*FaultStorage, error) {
+	var fs FaultStorage
+	fs.storage = storage
+	fs.rand = rand.New(rand.NewSource(options.Seed))
+	if err := fs.SetRules(options.Rules); err != nil {
+		return nil, err
+	}
+	return &fs, nil
+}
+
+// SetRules replaces the rules of faults and resets their hit counts. It fails with an
+// error wrapping ErrBadPattern, leaving the rules unchanged, if the key pattern of any
+// rule is malformed.
+func (fs *FaultStorage) SetRules(rules []Rule) error {
+	for i := range rules {
+		if err := glob.Validate(rules[i].KeyPattern); err != nil {
+			return fmt.Errorf("%w; ruleIndex=%d pattern=%q", ErrBadPattern, i, rules[i].KeyPattern)
+		}
+	}
+	fs.mu.Lock()
+	fs.rules = append([]Rule(nil), rules...)
+	fs.hits = make([]int, len(rules))
+	fs.mu.Unlock()
+	return nil
+}
+
+// Hits returns the number of times each rule has fired.
+func (fs *FaultStorage) Hits() []int {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return append([]int(nil), fs.hits...)
+}
+
+// GetValue implements versionedkv.Storage.GetValue.
+func (fs *FaultStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	if _, err := fs.inject(ctx, versionedkv.OperationGetValue, key); err != nil {
+		return "", nil, err
+	}
+	return fs.storage.GetValue(ctx, key)
+}
+
+// WaitForValue implements versionedkv.Storage.WaitForValue.
+func (fs *FaultStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	rule, err := fs.inject(ctx, versionedkv.OperationWaitForValue, key)
+	if err != nil {
+		return "", nil, err
+	}
+	if rule.SpuriousWakeup {
+		// Repeat the current state, which for a value which does not exist is a nil version.
+		return fs.storage.GetValue(ctx, key)
+	}
+	val, newVersion, err := fs.storage.WaitForValue(ctx, key, oldVersion)
+	if err != nil || !rule.DropNotification {
+		return val, newVersion, err
+	}
+	if newVersion == nil && oldVersion == nil {
+		return val, newVersion, err
+	}
+	return fs.storage.WaitForValue(ctx, key, newVersion)
+}
+
+// CreateValue implements versionedkv.Storage.CreateValue.
+func (fs *FaultStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	if _, err := fs.inject(ctx, versionedkv.OperationCreateValue, key); err != nil {
+		return nil, err
+	}
+	return fs.storage.CreateValue(ctx, key, val)
+}
+
+// UpdateValue implements versionedkv.Storage.UpdateValue.
+func (fs *FaultStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if _, err := fs.inject(ctx, versionedkv.OperationUpdateValue, key); err != nil {
+		return nil, err
+	}
+	return fs.storage.UpdateValue(ctx, key, val, oldVersion)
+}
+
+// CreateOrUpdateValue implements versionedkv.Storage.CreateOrUpdateValue.
+func (fs *FaultStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if _, err := fs.inject(ctx, versionedkv.OperationCreateOrUpdateValue, key); err != nil {
+		return nil, err
+	}
+	return fs.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+}
+
+// DeleteValue implements versionedkv.Storage.DeleteValue.
+func (fs *FaultStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	if _, err := fs.inject(ctx, versionedkv.OperationDeleteValue, key); err != nil {
+		return false, err
+	}
+	return fs.storage.DeleteValue(ctx, key, version)
+}
+
+// Close implements versionedkv.Storage.Close.
+func (fs *FaultStorage) Close() error {
+	return fs.storage.Close()
+}
+
+// Inspect implements versionedkv.Storage.Inspect.
+func (fs *FaultStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	if _, err := fs.inject(ctx, versionedkv.OperationInspect, ""); err != nil {
+		return versionedkv.StorageDetails{}, err
+	}
+	return fs.storage.Inspect(ctx)
+}
+
+// Capabilities implements versionedkv.CapabilityReporter.Capabilities, forwarding the
+// capabilities of the storage decorated.
+func (fs *FaultStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(fs.storage)
+}
+
+func (fs *FaultStorage) inject(ctx context.Context, operation string, key string) (Rule, error) {
+	rule, ok := fs.fire(operation, key)
+	if !ok {
+		return Rule{}, nil
+	}
+	if rule.Latency > 0 {
+		timer := time.NewTimer(rule.Latency)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return Rule{}, ctx.Err()
+		}
+	}
+	if rule.Err != nil {
+		return Rule{}, rule.Err
+	}
+	if rule.Closed {
+		return Rule{}, versionedkv.ErrStorageClosed
+	}
+	return rule, nil
+}
+
+func (fs *FaultStorage) fire(operation string, key string) (Rule, bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	for i := range fs.rules {
+		rule := &fs.rules[i]
+		if !rule.matches(operation, key) {
+			continue
+		}
+		if rule.MaxHits > 0 && fs.hits[i] >= rule.MaxHits {
+			continue
+		}
+		if rule.Probability > 0 && fs.rand.Float64() >= rule.Probability {
+			continue
+		}
+		fs.hits[i]++
+		return *rule, true
+	}
+	return Rule{}, false
+}
+
+func (r *Rule) matches(operation string, key string) bool {
+	if len(r.Operations) >= 1 {
+		found := false
+		for _, operation2 := range r.Operations {
+			if operation2 == operation {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.KeyPattern == "" {
+		return true
+	}
+	if operation == versionedkv.OperationInspect {
+		return false
+	}
+	// Key patterns have been validated by SetRules.
+	ok, err := glob.Match(r.KeyPattern, key)
+	return ok && err == nil
+}
+
+// ErrBadPattern is returned when the key pattern of a rule is malformed.
+var ErrBadPattern error = errors.New("faultstorage: bad pattern")
@@ -0,0 +1,162 @@
+package faultstorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	. "github.com/go-tk/versionedkv/faultstorage"
+	"github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		s, err := New(memorystorage.New(), Options{})
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	})
+}
+
+func TestFaultStorage_Err(t *testing.T) {
+	t.Parallel()
+	errFault := errors.New("fault")
+	s, err := New(memorystorage.New(), Options{
+		Rules: []Rule{
+			{Operations: []string{versionedkv.OperationCreateValue}, KeyPattern: "foo/*", Err: errFault, MaxHits: 1},
+			{Operations: []string{versionedkv.OperationGetValue}, Closed: true},
+		},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	_, err = s.CreateValue(ctx, "bar", "1")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "foo/1/2", "1")
+	assert.Equal(t, errFault, err)
+	_, err = s.CreateValue(ctx, "foo/1", "1")
+	assert.NoError(t, err)
+	_, _, err = s.GetValue(ctx, "bar")
+	assert.Equal(t, versionedkv.ErrStorageClosed, err)
+	assert.Equal(t, []int{1, 1}, s.Hits())
+}
+
+func TestFaultStorage_BadPattern(t *testing.T) {
+	t.Parallel()
+	_, err := New(memorystorage.New(), Options{Rules: []Rule{{}, {KeyPattern: "foo/["}}})
+	assert.True(t, errors.Is(err, ErrBadPattern))
+
+	s, err := New(memorystorage.New(), Options{Rules: []Rule{{Closed: true}}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	err = s.SetRules([]Rule{{KeyPattern: "foo/\\"}})
+	assert.True(t, errors.Is(err, ErrBadPattern))
+	_, _, err = s.GetValue(context.Background(), "foo")
+	assert.Equal(t, versionedkv.ErrStorageClosed, err)
+}
+
+func TestFaultStorage_Latency(t *testing.T) {
+	t.Parallel()
+	s, err := New(memorystorage.New(), Options{
+		Rules: []Rule{{Latency: 100 * time.Millisecond}},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	t0 := time.Now()
+	_, _, err = s.GetValue(context.Background(), "foo")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(t0) >= 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = s.GetValue(ctx, "foo")
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestFaultStorage_Probability(t *testing.T) {
+	t.Parallel()
+	run := func() []bool {
+		s, err := New(memorystorage.New(), Options{
+			Rules: []Rule{{Probability: 0.5, Closed: true}},
+			Seed:  42,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer s.Close()
+		var failures []bool
+		for i := 0; i < 100; i++ {
+			_, _, err := s.GetValue(context.Background(), "foo")
+			failures = append(failures, err != nil)
+		}
+		return failures
+	}
+	failures := run()
+	assert.Equal(t, failures, run())
+	var n int
+	for _, failure := range failures {
+		if failure {
+			n++
+		}
+	}
+	assert.True(t, n > 20 && n < 80, n)
+}
+
+func TestFaultStorage_WaitForValue(t *testing.T) {
+	t.Parallel()
+	s, err := New(memorystorage.New(), Options{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	ctx := context.Background()
+	version, err := s.CreateValue(ctx, "foo", "1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	if !assert.NoError(t, s.SetRules([]Rule{{SpuriousWakeup: true}})) {
+		t.FailNow()
+	}
+	val, newVersion, err := s.WaitForValue(ctx, "foo", version)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", val)
+	assert.Equal(t, version, newVersion)
+	val, newVersion, err = s.WaitForValue(ctx, "bar", version)
+	assert.NoError(t, err)
+	assert.Equal(t, "", val)
+	assert.Nil(t, newVersion)
+
+	if !assert.NoError(t, s.SetRules([]Rule{{DropNotification: true}})) {
+		t.FailNow()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		val, _, err := s.WaitForValue(ctx, "foo", version)
+		assert.NoError(t, err)
+		assert.Equal(t, "3", val)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	version, err = s.UpdateValue(ctx, "foo", "2", version)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	time.Sleep(50 * time.Millisecond)
+	_, err = s.UpdateValue(ctx, "foo", "3", version)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	<-done
+}
@@ -0,0 +1,148 @@
+//go:build go1.18
+// +build go1.18
+
+package versionedkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// DoFuzzStorage fuzzes storages created by the given storage factory.
+//
+// Each input is decoded into a sequence of operations on a few keys, three bytes per
+// operation: the operation, the key and the version given, which is either nil or one
+// of the versions returned so far, including stale ones. The operations are issued one
+// by one, and the result of each is checked against the model of Storage (see
+// CheckLinearizability), followed by a check of the values reported by Inspect.
+// WaitForValue which is expected to block is given a short timeout, which it must hit; to
+// keep inputs fast, only the first such call of an input really waits, the others are
+// given an expired deadline instead. Inputs are truncated to a few hundred operations.
+func DoFuzzStorage(f *testing.F, sf StorageFactory) {
+	const (
+		maxOperations             = 256
+		maxBlockingWaitsWithTimer = 1
+	)
+	for _, seed := range [][]byte{
+		{},
+		{2, 0, 0, 0, 0, 0, 3, 0, 1, 5, 0, 1, 5, 0, 2},
+		{4, 0, 0, 4, 0, 1, 4, 0, 0, 5, 0, 0, 4, 0, 1, 4, 0, 2},
+		{2, 0, 0, 3, 0, 1, 1, 0, 1, 1, 0, 2, 5, 0, 2, 1, 0, 2, 1, 0, 0},
+		{2, 0, 0, 2, 1, 0, 5, 1, 1, 3, 0, 2, 4, 1, 2, 5, 0, 0, 4, 2, 0},
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if len(data) > 3*maxOperations {
+			data = data[:3*maxOperations]
+		}
+		s, err := sf()
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		defer s.Close()
+		ctx := context.Background()
+		keys := [...]string{"foo", "bar", "baz"}
+		states := make(map[string]modelState)
+		var versions []Version
+		var operations []Operation
+		numberOfBlockingWaits := 0
+		fail := func(reason string) {
+			var builder strings.Builder
+			builder.WriteString(reason)
+			builder.WriteString("; operations:")
+			for i := range operations {
+				builder.WriteString("\n\t")
+				builder.WriteString(operations[i].String())
+			}
+			t.Fatal(builder.String())
+		}
+		for i := 0; i+2 < len(data); i += 3 {
+			key := keys[int(data[i+1])%len(keys)]
+			var version Version
+			if j := int(data[i+2]); j >= 1 && len(versions) >= 1 {
+				version = versions[(j-1)%len(versions)]
+			}
+			value := strconv.Itoa(i / 3)
+			state := states[key]
+			operation := Operation{Key: key}
+			switch data[i] % 6 {
+			case 0:
+				operation.Name = OperationGetValue
+				operation.Value, operation.NewVersion, operation.Err = s.GetValue(ctx, key)
+			case 1:
+				operation.Name = OperationWaitForValue
+				operation.OldVersion = version
+				if (!state.exists && version == nil) || (state.exists && versionsEqual(state.version, version)) {
+					timeout := time.Millisecond
+					if numberOfBlockingWaits++; numberOfBlockingWaits > maxBlockingWaitsWithTimer {
+						timeout = -1
+					}
+					ctx, cancel := context.WithTimeout(ctx, timeout)
+					operation.Value, operation.NewVersion, operation.Err = s.WaitForValue(ctx, key, version)
+					cancel()
+					operations = append(operations, operation)
+					if !errors.Is(operation.Err, context.DeadlineExceeded) {
+						fail("WaitForValue not blocking")
+					}
+					continue
+				}
+				operation.Value, operation.NewVersion, operation.Err = s.WaitForValue(ctx, key, version)
+			case 2:
+				operation.Name = OperationCreateValue
+				operation.Value = value
+				operation.NewVersion, operation.Err = s.CreateValue(ctx, key, value)
+			case 3:
+				operation.Name = OperationUpdateValue
+				operation.Value = value
+				operation.OldVersion = version
+				operation.NewVersion, operation.Err = s.UpdateValue(ctx, key, value, version)
+			case 4:
+				operation.Name = OperationCreateOrUpdateValue
+				operation.Value = value
+				operation.OldVersion = version
+				operation.NewVersion, operation.Err = s.CreateOrUpdateValue(ctx, key, value, version)
+			case 5:
+				operation.Name = OperationDeleteValue
+				operation.OldVersion = version
+				operation.OK, operation.Err = s.DeleteValue(ctx, key, version)
+			}
+			operations = append(operations, operation)
+			if operation.Err != nil {
+				fail("operation failed")
+			}
+			newState, ok := state.Step(&operation)
+			if !ok {
+				fail("unexpected result")
+			}
+			states[key] = newState
+			if operation.NewVersion != nil {
+				versions = append(versions, operation.NewVersion)
+			}
+		}
+		details, err := s.Inspect(ctx)
+		if err != nil {
+			fail(fmt.Sprintf("Inspect failed: %v", err))
+		}
+		expectedValues := make(map[string]ValueDetails)
+		for key, state := range states {
+			if state.exists {
+				expectedValues[key] = ValueDetails{V: state.value, Version: state.version}
+			}
+		}
+		values := details.Values
+		if values == nil {
+			values = make(map[string]ValueDetails)
+		}
+		if !assert.Equal(t, expectedValues, values) {
+			fail("unexpected values inspected")
+		}
+	})
+}
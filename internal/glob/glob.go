@@ -0,0 +1,128 @@
+// Package glob implements the glob patterns shared by the decorators of versionedkv,
+// which are in the syntax of path.Match, except that '*' matches any sequence of
+// characters, including '/', so that a pattern "a/*" covers nested keys such as
+// "a/b/c" as well.
+package glob
+
+import (
+	"errors"
+	"unicode/utf8"
+)
+
+// ErrBadPattern is returned when a pattern is malformed.
+var ErrBadPattern error = errors.New("glob: bad pattern")
+
+// Match reports whether the given name matches the given pattern.
+// The only possible returned error is ErrBadPattern, when the pattern is malformed.
+func Match(pattern string, name string) (bool, error) {
+	if err := Validate(pattern); err != nil {
+		return false, err
+	}
+	// Backtrack to the last '*' on mismatch, letting it match one more character.
+	starPatternIndex, starNameIndex := -1, 0
+	patternIndex, nameIndex := 0, 0
+	for patternIndex < len(pattern) || nameIndex < len(name) {
+		if patternIndex < len(pattern) {
+			if pattern[patternIndex] == '*' {
+				starPatternIndex, starNameIndex = patternIndex, nameIndex
+				patternIndex++
+				continue
+			}
+			if nameIndex < len(name) {
+				c, n := utf8.DecodeRuneInString(name[nameIndex:])
+				if m, ok := scanChar(pattern[patternIndex:], c); ok {
+					patternIndex += m
+					nameIndex += n
+					continue
+				}
+			}
+		}
+		if starPatternIndex >= 0 && starNameIndex < len(name) {
+			_, n := utf8.DecodeRuneInString(name[starNameIndex:])
+			starNameIndex += n
+			patternIndex, nameIndex = starPatternIndex+1, starNameIndex
+			continue
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
+// Validate returns ErrBadPattern if the given pattern is malformed.
+func Validate(pattern string) error {
+	for i := 0; i < len(pattern); {
+		if pattern[i] == '*' {
+			i++
+			continue
+		}
+		n, _ := scanChar(pattern[i:], -1)
+		if n == 0 {
+			return ErrBadPattern
+		}
+		i += n
+	}
+	return nil
+}
+
+// scanChar scans the token, other than '*', at the beginning of the given pattern, and
+// returns its length, which is 0 if the token is malformed, and whether the given
+// character matches it.
+func scanChar(pattern string, c rune) (int, bool) {
+	switch pattern[0] {
+	case '?':
+		return 1, true
+	case '\\':
+		if len(pattern) == 1 {
+			return 0, false
+		}
+		c2, n := utf8.DecodeRuneInString(pattern[1:])
+		return 1 + n, c2 == c
+	case '[':
+		i := 1
+		isNegated := false
+		if i < len(pattern) && pattern[i] == '^' {
+			isNegated = true
+			i++
+		}
+		ok := false
+		for numberOfRanges := 0; ; numberOfRanges++ {
+			if i < len(pattern) && pattern[i] == ']' && numberOfRanges >= 1 {
+				return i + 1, ok != isNegated
+			}
+			lo, n := scanClassChar(pattern[i:])
+			if n == 0 {
+				return 0, false
+			}
+			i += n
+			hi := lo
+			if i < len(pattern) && pattern[i] == '-' {
+				hi, n = scanClassChar(pattern[i+1:])
+				if n == 0 {
+					return 0, false
+				}
+				i += 1 + n
+			}
+			if lo <= c && c <= hi {
+				ok = true
+			}
+		}
+	default:
+		c2, n := utf8.DecodeRuneInString(pattern)
+		return n, c2 == c
+	}
+}
+
+func scanClassChar(pattern string) (rune, int) {
+	if len(pattern) == 0 || pattern[0] == '-' || pattern[0] == ']' {
+		return 0, 0
+	}
+	if pattern[0] == '\\' {
+		if len(pattern) == 1 {
+			return 0, 0
+		}
+		c, n := utf8.DecodeRuneInString(pattern[1:])
+		return c, 1 + n
+	}
+	c, n := utf8.DecodeRuneInString(pattern)
+	return c, n
+}
@@ -0,0 +1,55 @@
+package glob_test
+
+import (
+	"testing"
+
+	. "github.com/go-tk/versionedkv/internal/glob"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatch(t *testing.T) {
+	t.Parallel()
+	for _, tc := range []struct {
+		Pattern string
+		Name    string
+		OK      bool
+	}{
+		{"", "", true},
+		{"a", "a", true},
+		{"a", "ab", false},
+		{"*", "", true},
+		{"*", "a/b/c", true},
+		{"a*c", "abbc", true},
+		{"a*c", "ab/bc", true},
+		{"a*c", "abcd", false},
+		{"a*b*c", "a/b/x/c", true},
+		{"a?c", "a/c", true},
+		{"a?c", "ac", false},
+		{"?", "é", true},
+		{"[a-c]x", "bx", true},
+		{"[a-c]x", "dx", false},
+		{"[^a-c]x", "dx", true},
+		{"[^a-c]x", "ax", false},
+		{"[\\]]", "]", true},
+		{"\\*", "*", true},
+		{"\\*", "a", false},
+	} {
+		ok, err := Match(tc.Pattern, tc.Name)
+		if !assert.NoError(t, err, tc.Pattern) {
+			continue
+		}
+		assert.Equal(t, tc.OK, ok, "%q %q", tc.Pattern, tc.Name)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+	for _, pattern := range []string{"a/[", "a/[]", "a/[a-]", "a/\\", "[z"} {
+		assert.Equal(t, ErrBadPattern, Validate(pattern), pattern)
+		_, err := Match(pattern, "a/b")
+		assert.Equal(t, ErrBadPattern, err, pattern)
+	}
+	for _, pattern := range []string{"team-?", "[^x]*", "\\*", "a/[a-c0-9]/*"} {
+		assert.NoError(t, Validate(pattern), pattern)
+	}
+}
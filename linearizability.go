@@ -0,0 +1,457 @@
+package versionedkv
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Operation represents an operation in a history.
+type Operation struct {
+	// Name is the name of the operation.
+	Name string
+
+	// Key is the key given.
+	Key string
+
+	// Value is the value given to CreateValue, UpdateValue and CreateOrUpdateValue,
+	// or the value returned by GetValue and WaitForValue.
+	Value string
+
+	// OldVersion is the old-version given to WaitForValue, UpdateValue and
+	// CreateOrUpdateValue, or the version given to DeleteValue.
+	OldVersion Version
+
+	// NewVersion is the version returned by GetValue, or the new-version returned by
+	// WaitForValue, CreateValue, UpdateValue and CreateOrUpdateValue.
+	NewVersion Version
+
+	// OK is the result of DeleteValue.
+	OK bool
+
+	// Err is the error returned.
+	Err error
+
+	// CallTime and ReturnTime are the logical times when the operation was called and
+	// returned. Times are unique within a history.
+	CallTime   int64
+	ReturnTime int64
+}
+
+// String returns a readable representation of the operation.
+func (o *Operation) String() string {
+	var call, result string
+	switch o.Name {
+	case OperationGetValue:
+		call = fmt.Sprintf("%s(%q)", o.Name, o.Key)
+		result = fmt.Sprintf("%q, %v", o.Value, o.NewVersion)
+	case OperationWaitForValue:
+		call = fmt.Sprintf("%s(%q, %v)", o.Name, o.Key, o.OldVersion)
+		result = fmt.Sprintf("%q, %v", o.Value, o.NewVersion)
+	case OperationCreateValue:
+		call = fmt.Sprintf("%s(%q, %q)", o.Name, o.Key, o.Value)
+		result = fmt.Sprintf("%v", o.NewVersion)
+	case OperationUpdateValue, OperationCreateOrUpdateValue:
+		call = fmt.Sprintf("%s(%q, %q, %v)", o.Name, o.Key, o.Value, o.OldVersion)
+		result = fmt.Sprintf("%v", o.NewVersion)
+	case OperationDeleteValue:
+		call = fmt.Sprintf("%s(%q, %v)", o.Name, o.Key, o.OldVersion)
+		result = fmt.Sprintf("%v", o.OK)
+	default:
+		call = fmt.Sprintf("%s(%q)", o.Name, o.Key)
+	}
+	if o.Err != nil {
+		result = "error: " + o.Err.Error()
+	}
+	return fmt.Sprintf("[%d, %d] %s -> %s", o.CallTime, o.ReturnTime, call, result)
+}
+
+// History represents a history of operations on a storage.
+type History struct {
+	clock int64
+
+	mu         sync.Mutex
+	operations []Operation
+}
+
+// Operations returns the operations which have returned, in the order they returned.
+func (h *History) Operations() []Operation {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Operation(nil), h.operations...)
+}
+
+func (h *History) now() int64 {
+	return atomic.AddInt64(&h.clock, 1)
+}
+
+func (h *History) add(operation Operation) {
+	h.mu.Lock()
+	h.operations = append(h.operations, operation)
+	h.mu.Unlock()
+}
+
+// RecordHistory returns a storage which records operations on the given storage in the
+// given history. Close and Inspect pass through without being recorded.
+func RecordHistory(storage Storage, history *History) Storage {
+	return historyRecordingStorage{storage, history}
+}
+
+type historyRecordingStorage struct {
+	storage Storage
+	history *History
+}
+
+func (hrs historyRecordingStorage) GetValue(ctx context.Context, key string) (string, Version, error) {
+	operation := Operation{Name: OperationGetValue, Key: key, CallTime: hrs.history.now()}
+	value, version, err := hrs.storage.GetValue(ctx, key)
+	operation.ReturnTime = hrs.history.now()
+	operation.Value, operation.NewVersion, operation.Err = value, version, err
+	hrs.history.add(operation)
+	return value, version, err
+}
+
+func (hrs historyRecordingStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion Version) (string, Version, error) {
+	operation := Operation{Name: OperationWaitForValue, Key: key, OldVersion: oldVersion, CallTime: hrs.history.now()}
+	value, newVersion, err := hrs.storage.WaitForValue(ctx, key, oldVersion)
+	operation.ReturnTime = hrs.history.now()
+	operation.Value, operation.NewVersion, operation.Err = value, newVersion, err
+	hrs.history.add(operation)
+	return value, newVersion, err
+}
+
+func (hrs historyRecordingStorage) CreateValue(ctx context.Context, key, value string) (Version, error) {
+	operation := Operation{Name: OperationCreateValue, Key: key, Value: value, CallTime: hrs.history.now()}
+	version, err := hrs.storage.CreateValue(ctx, key, value)
+	operation.ReturnTime = hrs.history.now()
+	operation.NewVersion, operation.Err = version, err
+	hrs.history.add(operation)
+	return version, err
+}
+
+func (hrs historyRecordingStorage) UpdateValue(ctx context.Context, key, value string,
+	oldVersion Version) (Version, error) {
+	operation := Operation{Name: OperationUpdateValue, Key: key, Value: value, OldVersion: oldVersion,
+		CallTime: hrs.history.now()}
+	newVersion, err := hrs.storage.UpdateValue(ctx, key, value, oldVersion)
+	operation.ReturnTime = hrs.history.now()
+	operation.NewVersion, operation.Err = newVersion, err
+	hrs.history.add(operation)
+	return newVersion, err
+}
+
+func (hrs historyRecordingStorage) CreateOrUpdateValue(ctx context.Context, key, value string,
+	oldVersion Version) (Version, error) {
+	operation := Operation{Name: OperationCreateOrUpdateValue, Key: key, Value: value, OldVersion: oldVersion,
+		CallTime: hrs.history.now()}
+	newVersion, err := hrs.storage.CreateOrUpdateValue(ctx, key, value, oldVersion)
+	operation.ReturnTime = hrs.history.now()
+	operation.NewVersion, operation.Err = newVersion, err
+	hrs.history.add(operation)
+	return newVersion, err
+}
+
+func (hrs historyRecordingStorage) DeleteValue(ctx context.Context, key string, version Version) (bool, error) {
+	operation := Operation{Name: OperationDeleteValue, Key: key, OldVersion: version, CallTime: hrs.history.now()}
+	ok, err := hrs.storage.DeleteValue(ctx, key, version)
+	operation.ReturnTime = hrs.history.now()
+	operation.OK, operation.Err = ok, err
+	hrs.history.add(operation)
+	return ok, err
+}
+
+func (hrs historyRecordingStorage) Close() error {
+	return hrs.storage.Close()
+}
+
+func (hrs historyRecordingStorage) Inspect(ctx context.Context) (StorageDetails, error) {
+	return hrs.storage.Inspect(ctx)
+}
+
+// CheckLinearizability checks whether the given operations are linearizable with respect
+// to the model of Storage: there must be an order of the operations, consistent with
+// the order in which they were called and returned, in which every operation gets the
+// result it has got when done alone against the model.
+//
+// Versions are compared with reflect.DeepEqual, and a new version must differ from the
+// current version it replaces. A WaitForValue is regarded as taking effect at the moment
+// it stops blocking. GetValue and WaitForValue which failed with errors, e.g. timing out,
+// are left out, whereas writes which failed with errors are indeterminate, since they
+// may have been applied anyway, e.g. by remote storages before connections broke: they
+// may take effect at any moment after they were called, with new versions unknown until
+// observed, or take no effect at all.
+//
+// Since operations on different keys are independent of each other, each key is checked
+// on its own. On failure, a *LinearizabilityError is returned.
+func CheckLinearizability(operations []Operation) error {
+	operationsByKey := make(map[string][]*Operation)
+	for i := range operations {
+		operation := &operations[i]
+		if operation.Err != nil && (operation.Name == OperationGetValue || operation.Name == OperationWaitForValue) {
+			continue
+		}
+		operationsByKey[operation.Key] = append(operationsByKey[operation.Key], operation)
+	}
+	keys := make([]string, 0, len(operationsByKey))
+	for key := range operationsByKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		operations := operationsByKey[key]
+		if isLinearizable(operations) {
+			continue
+		}
+		sort.Slice(operations, func(i, j int) bool { return operations[i].CallTime < operations[j].CallTime })
+		linearizabilityError := LinearizabilityError{Key: key}
+		for _, operation := range operations {
+			linearizabilityError.Operations = append(linearizabilityError.Operations, *operation)
+		}
+		return &linearizabilityError
+	}
+	return nil
+}
+
+// LinearizabilityError is returned by CheckLinearizability when the operations on a key
+// are not linearizable.
+type LinearizabilityError struct {
+	// Key is the key.
+	Key string
+
+	// Operations are the operations on the key, in the order they were called.
+	Operations []Operation
+}
+
+// Error implements error.Error.
+func (le *LinearizabilityError) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "versionedkv: operations not linearizable; key=%q", le.Key)
+	for i := range le.Operations {
+		builder.WriteString("\n\t")
+		builder.WriteString(le.Operations[i].String())
+	}
+	return builder.String()
+}
+
+type modelState struct {
+	exists  bool
+	value   string
+	version Version
+
+	// isVersionUnknown indicates that the value was written by a failed write, so its
+	// version is unknown until observed. Such a version has never been seen by callers,
+	// so it differs from any version given.
+	isVersionUnknown bool
+}
+
+func (ms modelState) Step(operation *Operation) (modelState, bool) {
+	if operation.Err != nil {
+		return ms.stepFailedWrite(operation), true
+	}
+	switch operation.Name {
+	case OperationGetValue:
+		if operation.NewVersion == nil {
+			return ms, !ms.exists
+		}
+		return ms.observe(operation)
+	case OperationWaitForValue:
+		if operation.NewVersion == nil {
+			return ms, !ms.exists && operation.OldVersion != nil
+		}
+		if operation.OldVersion != nil && ms.hasVersion(operation.OldVersion) {
+			return ms, false
+		}
+		return ms.observe(operation)
+	case OperationCreateValue:
+		if operation.NewVersion == nil {
+			return ms, ms.exists
+		}
+		return modelState{exists: true, value: operation.Value, version: operation.NewVersion}, !ms.exists
+	case OperationUpdateValue:
+		if operation.NewVersion == nil {
+			return ms, !ms.exists || (operation.OldVersion != nil && !ms.hasVersion(operation.OldVersion))
+		}
+		return modelState{exists: true, value: operation.Value, version: operation.NewVersion}, ms.exists &&
+			(operation.OldVersion == nil || ms.hasVersion(operation.OldVersion)) &&
+			!ms.hasVersion(operation.NewVersion)
+	case OperationCreateOrUpdateValue:
+		if operation.NewVersion == nil {
+			return ms, ms.exists && operation.OldVersion != nil && !ms.hasVersion(operation.OldVersion)
+		}
+		return modelState{exists: true, value: operation.Value, version: operation.NewVersion}, !ms.exists ||
+			((operation.OldVersion == nil || ms.hasVersion(operation.OldVersion)) &&
+				!ms.hasVersion(operation.NewVersion))
+	case OperationDeleteValue:
+		if !operation.OK {
+			return ms, !ms.exists || (operation.OldVersion != nil && !ms.hasVersion(operation.OldVersion))
+		}
+		return modelState{}, ms.exists && (operation.OldVersion == nil || ms.hasVersion(operation.OldVersion))
+	default:
+		return ms, false
+	}
+}
+
+// stepFailedWrite applies the given failed write as if it had succeeded, if possible.
+// Taking no effect at all is covered by taking effect after all other operations.
+func (ms modelState) stepFailedWrite(operation *Operation) modelState {
+	switch operation.Name {
+	case OperationCreateValue:
+		if ms.exists {
+			return ms
+		}
+	case OperationUpdateValue:
+		if !ms.exists || (operation.OldVersion != nil && !ms.hasVersion(operation.OldVersion)) {
+			return ms
+		}
+	case OperationCreateOrUpdateValue:
+		if ms.exists && operation.OldVersion != nil && !ms.hasVersion(operation.OldVersion) {
+			return ms
+		}
+	case OperationDeleteValue:
+		if !ms.exists || (operation.OldVersion != nil && !ms.hasVersion(operation.OldVersion)) {
+			return ms
+		}
+		return modelState{}
+	}
+	return modelState{exists: true, value: operation.Value, isVersionUnknown: true}
+}
+
+// observe checks the value and the version returned by the given read, learning the
+// version if unknown.
+func (ms modelState) observe(operation *Operation) (modelState, bool) {
+	if !ms.exists || ms.value != operation.Value {
+		return ms, false
+	}
+	if ms.isVersionUnknown {
+		return modelState{exists: true, value: ms.value, version: operation.NewVersion}, true
+	}
+	return ms, versionsEqual(ms.version, operation.NewVersion)
+}
+
+func (ms modelState) hasVersion(version Version) bool {
+	return !ms.isVersionUnknown && versionsEqual(ms.version, version)
+}
+
+func (ms modelState) Fingerprint() string {
+	return fmt.Sprintf("%t %q %#v %t", ms.exists, ms.value, ms.version, ms.isVersionUnknown)
+}
+
+func versionsEqual(version1, version2 Version) bool {
+	return reflect.DeepEqual(version1, version2)
+}
+
+type historyEvent struct {
+	Operation      *Operation
+	OperationIndex int
+	Time           int64
+	Return         *historyEvent // nil for return events
+
+	prev, next *historyEvent
+}
+
+// isLinearizable searches for a linearization of the given operations with the algorithm
+// of Wing & Gong, memoizing visited configurations as suggested by Lowe.
+func isLinearizable(operations []*Operation) bool {
+	maxTime := int64(0)
+	for _, operation := range operations {
+		if operation.ReturnTime > maxTime {
+			maxTime = operation.ReturnTime
+		}
+	}
+	events := make([]*historyEvent, 0, 2*len(operations))
+	for i, operation := range operations {
+		returnTime := operation.ReturnTime
+		if operation.Err != nil {
+			// Failed writes may take effect at any moment after they were called.
+			returnTime = maxTime + 1 + int64(i)
+		}
+		returnEvent := historyEvent{Operation: operation, OperationIndex: i, Time: returnTime}
+		callEvent := historyEvent{Operation: operation, OperationIndex: i, Time: operation.CallTime, Return: &returnEvent}
+		events = append(events, &callEvent, &returnEvent)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Time < events[j].Time })
+	var head historyEvent
+	prev := &head
+	for _, event := range events {
+		prev.next = event
+		event.prev = prev
+		prev = event
+	}
+	type frame struct {
+		CallEvent *historyEvent
+		State     modelState
+	}
+	var stack []frame
+	var state modelState
+	linearized := make([]uint64, (len(operations)+63)/64)
+	visited := make(map[string]struct{})
+	for event := head.next; head.next != nil; {
+		if event.Return != nil {
+			if newState, ok := state.Step(event.Operation); ok {
+				setBit(linearized, event.OperationIndex)
+				configuration := makeConfiguration(linearized, newState)
+				if _, ok := visited[configuration]; !ok {
+					visited[configuration] = struct{}{}
+					stack = append(stack, frame{event, state})
+					state = newState
+					event.Lift()
+					event = head.next
+					continue
+				}
+				clearBit(linearized, event.OperationIndex)
+			}
+			event = event.next
+			continue
+		}
+		// The operation of the return event has not been linearized, so backtrack.
+		if len(stack) == 0 {
+			return false
+		}
+		frame := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		event, state = frame.CallEvent, frame.State
+		clearBit(linearized, event.OperationIndex)
+		event.Unlift()
+		event = event.next
+	}
+	return true
+}
+
+// Lift removes the call event and its return event from the list.
+func (he *historyEvent) Lift() {
+	he.prev.next = he.next
+	he.next.prev = he.prev
+	returnEvent := he.Return
+	returnEvent.prev.next = returnEvent.next
+	if returnEvent.next != nil {
+		returnEvent.next.prev = returnEvent.prev
+	}
+}
+
+// Unlift puts the call event and its return event back into the list.
+func (he *historyEvent) Unlift() {
+	returnEvent := he.Return
+	returnEvent.prev.next = returnEvent
+	if returnEvent.next != nil {
+		returnEvent.next.prev = returnEvent
+	}
+	he.prev.next = he
+	he.next.prev = he
+}
+
+func setBit(bits []uint64, i int)   { bits[i/64] |= 1 << uint(i%64) }
+func clearBit(bits []uint64, i int) { bits[i/64] &^= 1 << uint(i%64) }
+
+func makeConfiguration(linearized []uint64, state modelState) string {
+	buffer := make([]byte, 8*len(linearized))
+	for i, word := range linearized {
+		binary.LittleEndian.PutUint64(buffer[8*i:], word)
+	}
+	return string(buffer) + state.Fingerprint()
+}
@@ -0,0 +1,117 @@
+package versionedkv_test
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/go-tk/versionedkv"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLinearizability(t *testing.T) {
+	t.Parallel()
+	for _, tt := range []struct {
+		Name         string
+		Operations   []Operation
+		Linearizable bool
+	}{
+		{
+			Name: "concurrent writes",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 4},
+				{Name: OperationCreateOrUpdateValue, Key: "k", Value: "b", NewVersion: 2, CallTime: 2, ReturnTime: 5},
+				{Name: OperationGetValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 3, ReturnTime: 6},
+				{Name: OperationGetValue, Key: "k", Value: "b", NewVersion: 2, CallTime: 7, ReturnTime: 8},
+			},
+			Linearizable: true,
+		},
+		{
+			Name: "stale read",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationUpdateValue, Key: "k", Value: "b", OldVersion: 1, NewVersion: 2, CallTime: 3, ReturnTime: 4},
+				{Name: OperationGetValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 5, ReturnTime: 6},
+			},
+		},
+		{
+			Name: "lost update",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationUpdateValue, Key: "k", Value: "b", OldVersion: 1, NewVersion: 2, CallTime: 3, ReturnTime: 6},
+				{Name: OperationUpdateValue, Key: "k", Value: "c", OldVersion: 1, NewVersion: 3, CallTime: 4, ReturnTime: 5},
+			},
+		},
+		{
+			Name: "wait for deletion",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationWaitForValue, Key: "k", OldVersion: 1, CallTime: 3, ReturnTime: 6},
+				{Name: OperationDeleteValue, Key: "k", OK: true, CallTime: 4, ReturnTime: 5},
+				{Name: OperationWaitForValue, Key: "k", OldVersion: 1, Err: errors.New("timeout"), CallTime: 7, ReturnTime: 8},
+			},
+			Linearizable: true,
+		},
+		{
+			Name: "spurious wakeup",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationWaitForValue, Key: "k", Value: "a", OldVersion: 1, NewVersion: 1, CallTime: 3, ReturnTime: 4},
+			},
+		},
+		{
+			Name: "failed write taking effect",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationUpdateValue, Key: "k", Value: "b", OldVersion: 1, Err: errors.New("timeout"), CallTime: 3, ReturnTime: 4},
+				{Name: OperationGetValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 5, ReturnTime: 6},
+				{Name: OperationGetValue, Key: "k", Value: "b", NewVersion: 2, CallTime: 7, ReturnTime: 8},
+				{Name: OperationUpdateValue, Key: "k", Value: "c", OldVersion: 2, NewVersion: 3, CallTime: 9, ReturnTime: 10},
+			},
+			Linearizable: true,
+		},
+		{
+			Name: "failed write taking no effect",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationDeleteValue, Key: "k", Err: errors.New("timeout"), CallTime: 3, ReturnTime: 4},
+				{Name: OperationGetValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 5, ReturnTime: 6},
+			},
+			Linearizable: true,
+		},
+		{
+			Name: "failed write observed before call",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationGetValue, Key: "k", Value: "b", NewVersion: 2, CallTime: 3, ReturnTime: 4},
+				{Name: OperationCreateOrUpdateValue, Key: "k", Value: "b", Err: errors.New("timeout"), CallTime: 5, ReturnTime: 6},
+			},
+		},
+		{
+			Name: "failed write observed with different versions",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k", Value: "a", Err: errors.New("timeout"), CallTime: 1, ReturnTime: 2},
+				{Name: OperationGetValue, Key: "k", Value: "a", NewVersion: 1, CallTime: 3, ReturnTime: 4},
+				{Name: OperationGetValue, Key: "k", Value: "a", NewVersion: 2, CallTime: 5, ReturnTime: 6},
+			},
+		},
+		{
+			Name: "independent keys",
+			Operations: []Operation{
+				{Name: OperationCreateValue, Key: "k1", Value: "a", NewVersion: 1, CallTime: 1, ReturnTime: 2},
+				{Name: OperationCreateValue, Key: "k2", Value: "a", NewVersion: 1, CallTime: 3, ReturnTime: 4},
+				{Name: OperationDeleteValue, Key: "k1", OldVersion: 2, CallTime: 5, ReturnTime: 6},
+			},
+			Linearizable: true,
+		},
+	} {
+		err := CheckLinearizability(tt.Operations)
+		if tt.Linearizable {
+			assert.NoError(t, err, tt.Name)
+			continue
+		}
+		var linearizabilityError *LinearizabilityError
+		if assert.True(t, errors.As(err, &linearizabilityError), tt.Name) {
+			assert.Equal(t, "k", linearizabilityError.Key, tt.Name)
+		}
+	}
+}
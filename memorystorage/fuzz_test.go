@@ -0,0 +1,17 @@
+//go:build go1.18
+// +build go1.18
+
+package memorystorage_test
+
+import (
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	. "github.com/go-tk/versionedkv/memorystorage"
+)
+
+func FuzzMemoryStorage(f *testing.F) {
+	versionedkv.DoFuzzStorage(f, func() (versionedkv.Storage, error) {
+		return New(), nil
+	})
+}
@@ -28,6 +28,15 @@ func (v *Value) Get() (string, Version, error) {
 	return v.v, v.version, nil
 }
 
+func (v *Value) GetWithWatchers() (string, Version, int, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.isRemoved {
+		return "", 0, 0, ErrValueRemoved
+	}
+	return v.v, v.version, len(v.watchers), nil
+}
+
 func (v *Value) AddWatcher() (Watcher, error) {
 	v.mu.Lock()
 	defer v.mu.Unlock()
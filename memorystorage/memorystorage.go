@@ -276,24 +276,44 @@ func (ms *memoryStorage) Inspect(_ context.Context) (versionedkv.StorageDetails,
 		return versionedkv.StorageDetails{IsClosed: true}, nil
 	}
 	var valueDetails map[string]versionedkv.ValueDetails
+	var numberOfWatchers int
 	ms.values.Range(func(opaqueKey, opaqueValue interface{}) bool {
+		key := opaqueKey.(string)
+		value := opaqueValue.(*internal.Value)
+		val, version, numberOfWatchers1, err := value.GetWithWatchers()
+		if err != nil {
+			return true
+		}
+		numberOfWatchers += numberOfWatchers1
+		if version == 0 && numberOfWatchers1 >= 1 {
+			// A placeholder for watchers waiting for the value to be created. Placeholders
+			// without watchers are leaks, so they are reported.
+			return true
+		}
 		if valueDetails == nil {
 			valueDetails = make(map[string]versionedkv.ValueDetails)
 		}
-		key := opaqueKey.(string)
-		value := opaqueValue.(*internal.Value)
-		val, version, _ := value.Get()
 		valueDetails[key] = versionedkv.ValueDetails{
 			V:       val,
-			Version: version,
+			Version: version2OpaqueVersion(version),
 		}
 		return true
 	})
 	return versionedkv.StorageDetails{
-		Values: valueDetails,
+		Values:           valueDetails,
+		NumberOfWatchers: numberOfWatchers,
 	}, nil
 }
 
+func (*memoryStorage) Capabilities() []versionedkv.Capability {
+	return []versionedkv.Capability{
+		versionedkv.CapabilityBinaryValues,
+		versionedkv.CapabilityWatcherCounting,
+		versionedkv.CapabilityLeakChecking,
+		versionedkv.CapabilityLinearizability,
+	}
+}
+
 func (ms *memoryStorage) isClosed() bool {
 	return atomic.LoadInt32(&ms.isClosed1) != 0
 }
@@ -1,10 +1,14 @@
 package memorystorage_test
 
 import (
+	"context"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-tk/versionedkv"
 	. "github.com/go-tk/versionedkv/memorystorage"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestMemoryStorage(t *testing.T) {
@@ -12,3 +16,49 @@ func TestMemoryStorage(t *testing.T) {
 		return New(), nil
 	})
 }
+
+func BenchmarkMemoryStorage(b *testing.B) {
+	versionedkv.DoBenchmarkStorage(b, func() (versionedkv.Storage, error) {
+		return New(), nil
+	})
+}
+
+func TestMemoryStorage_Inspect(t *testing.T) {
+	t.Parallel()
+	s := New()
+	defer s.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	version, err := s.CreateValue(ctx, "foo", "123")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	var wg sync.WaitGroup
+	for _, oldVersion := range []versionedkv.Version{version, nil, nil} {
+		key := "foo"
+		if oldVersion == nil {
+			key = "bar"
+		}
+		oldVersion := oldVersion
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.WaitForValue(ctx, key, oldVersion)
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
+	// Placeholders for "bar" are left out.
+	details, err := s.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, versionedkv.StorageDetails{
+		Values: map[string]versionedkv.ValueDetails{
+			"foo": {V: "123", Version: version},
+		},
+		NumberOfWatchers: 3,
+	}, details)
+	cancel()
+	wg.Wait()
+	details, err = s.Inspect(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, details.NumberOfWatchers)
+}
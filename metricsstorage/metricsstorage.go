@@ -0,0 +1,147 @@
+// Package metricsstorage provides a decorator of versionedkv recording metrics of storage
+// operations.
+//
+// The following metrics are recorded:
+//
+//	versionedkv_operations_total{operation}            counter
+//	versionedkv_operation_errors_total{operation}      counter
+//	versionedkv_storage_closed_errors_total{operation} counter
+//	versionedkv_operation_duration_seconds{operation}  histogram
+//	versionedkv_conflicts_total{operation}             counter
+//	versionedkv_active_waiters                         gauge
+//
+// A conflict is a nil version returned from CreateValue, UpdateValue or CreateOrUpdateValue,
+// i.e. a failed compare-and-swap.
+package metricsstorage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// New creates a new storage recording metrics of operations on the given storage to the
+// given registry.
+func New(storage versionedkv.Storage, registry Registry) versionedkv.Storage {
+	operationLabelNames := []string{"operation"}
+	return &metricsStorage{
+		storage: storage,
+
+		operations: registry.NewCounter("versionedkv_operations_total",
+			"Total number of storage operations.", operationLabelNames),
+		operationErrors: registry.NewCounter("versionedkv_operation_errors_total",
+			"Total number of storage operations that failed.", operationLabelNames),
+		storageClosedErrors: registry.NewCounter("versionedkv_storage_closed_errors_total",
+			"Total number of storage operations that failed because the storage had been closed.",
+			operationLabelNames),
+		operationDuration: registry.NewHistogram("versionedkv_operation_duration_seconds",
+			"Duration of storage operations in seconds.", operationLabelNames, DefaultBuckets),
+		conflicts: registry.NewCounter("versionedkv_conflicts_total",
+			"Total number of version conflicts of write operations.", operationLabelNames),
+		activeWaiters: registry.NewGauge("versionedkv_active_waiters",
+			"Number of WaitForValue calls in progress.", nil),
+	}
+}
+
+type metricsStorage struct {
+	storage versionedkv.Storage
+
+	operations          Counter
+	operationErrors     Counter
+	storageClosedErrors Counter
+	operationDuration   Histogram
+	conflicts           Counter
+	activeWaiters       Gauge
+}
+
+func (ms *metricsStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	defer ms.startOperation(versionedkv.OperationGetValue)()
+	val, version, err := ms.storage.GetValue(ctx, key)
+	ms.endOperation(versionedkv.OperationGetValue, err)
+	return val, version, err
+}
+
+func (ms *metricsStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	ms.activeWaiters.Add(1)
+	defer ms.activeWaiters.Add(-1)
+	defer ms.startOperation(versionedkv.OperationWaitForValue)()
+	val, newVersion, err := ms.storage.WaitForValue(ctx, key, oldVersion)
+	ms.endOperation(versionedkv.OperationWaitForValue, err)
+	return val, newVersion, err
+}
+
+func (ms *metricsStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	defer ms.startOperation(versionedkv.OperationCreateValue)()
+	version, err := ms.storage.CreateValue(ctx, key, val)
+	ms.endWriteOperation(versionedkv.OperationCreateValue, version, err)
+	return version, err
+}
+
+func (ms *metricsStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	defer ms.startOperation(versionedkv.OperationUpdateValue)()
+	newVersion, err := ms.storage.UpdateValue(ctx, key, val, oldVersion)
+	ms.endWriteOperation(versionedkv.OperationUpdateValue, newVersion, err)
+	return newVersion, err
+}
+
+func (ms *metricsStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	defer ms.startOperation(versionedkv.OperationCreateOrUpdateValue)()
+	newVersion, err := ms.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+	ms.endWriteOperation(versionedkv.OperationCreateOrUpdateValue, newVersion, err)
+	return newVersion, err
+}
+
+func (ms *metricsStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	defer ms.startOperation(versionedkv.OperationDeleteValue)()
+	ok, err := ms.storage.DeleteValue(ctx, key, version)
+	ms.endOperation(versionedkv.OperationDeleteValue, err)
+	return ok, err
+}
+
+func (ms *metricsStorage) Close() error {
+	defer ms.startOperation(versionedkv.OperationClose)()
+	err := ms.storage.Close()
+	ms.endOperation(versionedkv.OperationClose, err)
+	return err
+}
+
+func (ms *metricsStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	defer ms.startOperation(versionedkv.OperationInspect)()
+	details, err := ms.storage.Inspect(ctx)
+	ms.endOperation(versionedkv.OperationInspect, err)
+	return details, err
+}
+
+func (ms *metricsStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(ms.storage)
+}
+
+func (ms *metricsStorage) startOperation(operation string) func() {
+	t := time.Now()
+	return func() {
+		ms.operationDuration.Observe(time.Since(t).Seconds(), operation)
+	}
+}
+
+func (ms *metricsStorage) endOperation(operation string, err error) {
+	ms.operations.Add(1, operation)
+	if err == nil {
+		return
+	}
+	ms.operationErrors.Add(1, operation)
+	if errors.Is(err, versionedkv.ErrStorageClosed) {
+		ms.storageClosedErrors.Add(1, operation)
+	}
+}
+
+func (ms *metricsStorage) endWriteOperation(operation string, newVersion versionedkv.Version, err error) {
+	ms.endOperation(operation, err)
+	if err == nil && newVersion == nil {
+		ms.conflicts.Add(1, operation)
+	}
+}
@@ -0,0 +1,87 @@
+package metricsstorage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/metricsstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), NewTextRegistry()), nil
+	})
+}
+
+func TestTextRegistry_ServeHTTP(t *testing.T) {
+	t.Parallel()
+	tr := NewTextRegistry()
+	s := New(memorystorage.New(), tr)
+	ctx := context.Background()
+
+	_, _, err := s.GetValue(ctx, "foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	version, err := s.CreateValue(ctx, "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.CreateValue(ctx, "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = s.WaitForValue(ctx, "foo", nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.DeleteValue(ctx, "foo", version)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	err = s.Close()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.UpdateValue(ctx, "foo", "bar", nil)
+	assert.Equal(t, versionedkv.ErrStorageClosed, err)
+
+	server := httptest.NewServer(tr)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer resp.Body.Close()
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain; version=0.0.4"))
+	data, err := ioutil.ReadAll(resp.Body)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	text := string(data)
+	for _, line := range []string{
+		"# TYPE versionedkv_operations_total counter",
+		`versionedkv_operations_total{operation="GetValue"} 1`,
+		`versionedkv_operations_total{operation="CreateValue"} 2`,
+		`versionedkv_operations_total{operation="WaitForValue"} 1`,
+		`versionedkv_operations_total{operation="DeleteValue"} 1`,
+		`versionedkv_operations_total{operation="UpdateValue"} 1`,
+		`versionedkv_conflicts_total{operation="CreateValue"} 1`,
+		`versionedkv_operation_errors_total{operation="UpdateValue"} 1`,
+		`versionedkv_storage_closed_errors_total{operation="UpdateValue"} 1`,
+		"# TYPE versionedkv_active_waiters gauge",
+		"versionedkv_active_waiters 0",
+		"# TYPE versionedkv_operation_duration_seconds histogram",
+		`versionedkv_operation_duration_seconds_bucket{operation="GetValue",le="+Inf"} 1`,
+		`versionedkv_operation_duration_seconds_count{operation="GetValue"} 1`,
+	} {
+		assert.Contains(t, text, line+"\n")
+	}
+}
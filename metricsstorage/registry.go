@@ -0,0 +1,222 @@
+package metricsstorage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry creates metrics. It is small enough to be adapted to any metrics library.
+type Registry interface {
+	// NewCounter creates a counter with the given name, help text and label names.
+	NewCounter(name, help string, labelNames []string) Counter
+
+	// NewGauge creates a gauge with the given name, help text and label names.
+	NewGauge(name, help string, labelNames []string) Gauge
+
+	// NewHistogram creates a histogram with the given name, help text, label names and
+	// upper bounds of buckets.
+	NewHistogram(name, help string, labelNames []string, buckets []float64) Histogram
+}
+
+// Counter represents a counter, which only goes up.
+type Counter interface {
+	// Add adds the given delta to the series with the given label values.
+	Add(delta float64, labelValues ...string)
+}
+
+// Gauge represents a gauge, which goes up and down.
+type Gauge interface {
+	// Add adds the given delta to the series with the given label values.
+	Add(delta float64, labelValues ...string)
+}
+
+// Histogram represents a histogram.
+type Histogram interface {
+	// Observe adds the given value to the series with the given label values.
+	Observe(value float64, labelValues ...string)
+}
+
+// DefaultBuckets is the default upper bounds of buckets of latency histograms in seconds.
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60}
+
+// TextRegistry is a registry exporting metrics in the Prometheus text format.
+type TextRegistry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+var _ Registry = (*TextRegistry)(nil)
+
+// NewTextRegistry creates a new text registry.
+func NewTextRegistry() *TextRegistry {
+	return &TextRegistry{}
+}
+
+// NewCounter implements Registry.NewCounter.
+func (tr *TextRegistry) NewCounter(name, help string, labelNames []string) Counter {
+	return tr.addMetric(name, help, "counter", labelNames, nil)
+}
+
+// NewGauge implements Registry.NewGauge.
+func (tr *TextRegistry) NewGauge(name, help string, labelNames []string) Gauge {
+	return tr.addMetric(name, help, "gauge", labelNames, nil)
+}
+
+// NewHistogram implements Registry.NewHistogram.
+func (tr *TextRegistry) NewHistogram(name, help string, labelNames []string, buckets []float64) Histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return tr.addMetric(name, help, "histogram", labelNames, buckets)
+}
+
+func (tr *TextRegistry) addMetric(name, help, typ string, labelNames []string, buckets []float64) *metric {
+	metric := &metric{
+		registry:   tr,
+		name:       name,
+		help:       help,
+		typ:        typ,
+		labelNames: labelNames,
+		buckets:    buckets,
+		series:     make(map[string]*series),
+	}
+	tr.mu.Lock()
+	tr.metrics = append(tr.metrics, metric)
+	tr.mu.Unlock()
+	return metric
+}
+
+// WriteText writes all metrics to the given writer in the Prometheus text format.
+func (tr *TextRegistry) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	tr.mu.Lock()
+	metrics := append([]*metric(nil), tr.metrics...)
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+	for _, metric := range metrics {
+		metric.writeText(bw)
+	}
+	tr.mu.Unlock()
+	return bw.Flush()
+}
+
+// ServeHTTP serves all metrics in the Prometheus text format.
+func (tr *TextRegistry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	tr.WriteText(w)
+}
+
+type metric struct {
+	registry   *TextRegistry
+	name       string
+	help       string
+	typ        string
+	labelNames []string
+	buckets    []float64
+	series     map[string]*series
+}
+
+type series struct {
+	labelValues  []string
+	value        float64
+	bucketCounts []uint64
+	count        uint64
+}
+
+func (m *metric) Add(delta float64, labelValues ...string) {
+	m.registry.mu.Lock()
+	m.getSeries(labelValues).value += delta
+	m.registry.mu.Unlock()
+}
+
+func (m *metric) Observe(value float64, labelValues ...string) {
+	m.registry.mu.Lock()
+	series := m.getSeries(labelValues)
+	series.value += value
+	series.count++
+	for i, bucket := range m.buckets {
+		if value <= bucket {
+			series.bucketCounts[i]++
+		}
+	}
+	m.registry.mu.Unlock()
+}
+
+func (m *metric) getSeries(labelValues []string) *series {
+	if len(labelValues) != len(m.labelNames) {
+		panic(fmt.Sprintf("metricsstorage: wrong number of label values; metricName=%q", m.name))
+	}
+	seriesKey := strings.Join(labelValues, "\xff")
+	s, ok := m.series[seriesKey]
+	if !ok {
+		s = &series{
+			labelValues:  append([]string(nil), labelValues...),
+			bucketCounts: make([]uint64, len(m.buckets)),
+		}
+		m.series[seriesKey] = s
+	}
+	return s
+}
+
+func (m *metric) writeText(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+	seriesKeys := make([]string, 0, len(m.series))
+	for seriesKey := range m.series {
+		seriesKeys = append(seriesKeys, seriesKey)
+	}
+	sort.Strings(seriesKeys)
+	for _, seriesKey := range seriesKeys {
+		s := m.series[seriesKey]
+		if m.typ != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.formatLabels(s.labelValues, ""), formatFloat(s.value))
+			continue
+		}
+		for i, bucket := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.formatLabels(s.labelValues, formatFloat(bucket)),
+				s.bucketCounts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.formatLabels(s.labelValues, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.formatLabels(s.labelValues, ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.formatLabels(s.labelValues, ""), s.count)
+	}
+}
+
+func (m *metric) formatLabels(labelValues []string, le string) string {
+	var labels []string
+	for i, labelName := range m.labelNames {
+		labels = append(labels, fmt.Sprintf("%s=\"%s\"", labelName, escapeLabelValue(labelValues[i])))
+	}
+	if le != "" {
+		labels = append(labels, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(labels) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+var (
+	helpEscaper       = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelValueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(help string) string { return helpEscaper.Replace(help) }
+
+func escapeLabelValue(labelValue string) string { return labelValueEscaper.Replace(labelValue) }
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, +1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(f, 'g', -1, 64)
+	}
+}
@@ -0,0 +1,198 @@
+// Package pubsub provides publish/subscribe topics on top of versionedkv.
+//
+// Publishers write messages to the key of a topic, and subscribers chase the versions of
+// the key with WaitForValue. As WaitForValue only reports the latest value, messages
+// published in quick succession would be coalesced; to avoid that, the most recent messages
+// are kept in a bounded ring buffer within the value, and subscribers pick up every message
+// they have not seen yet from the buffer.
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for topics.
+type Options struct {
+	// KeyPrefix is prepended to topic names to form keys.
+	// The default value is "pubsub/".
+	KeyPrefix string
+
+	// BufferSize is the number of the most recent messages kept for subscribers.
+	// The default value is 1, which means only the latest message is kept.
+	BufferSize int
+}
+
+func (o *Options) sanitize() {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "pubsub/"
+	}
+	if o.BufferSize <= 0 {
+		o.BufferSize = 1
+	}
+}
+
+// Message represents a message published to a topic.
+type Message struct {
+	// Seq is the sequence number of the message, which starts from 1 and increases by 1 with
+	// each message published to the topic.
+	Seq     uint64
+	Payload string
+
+	// Missed is the number of messages the subscriber missed right before the message,
+	// because they had been evicted from the buffer before the subscriber caught up.
+	Missed uint64
+}
+
+// Topic represents a publish/subscribe topic.
+type Topic struct {
+	storage versionedkv.Storage
+	key     string
+	options Options
+}
+
+// New creates a new topic with the given name on the given storage.
+func New(storage versionedkv.Storage, name string, options Options) *Topic {
+	options.sanitize()
+	return &Topic{
+		storage: storage,
+		key:     options.KeyPrefix + name,
+		options: options,
+	}
+}
+
+// Publish publishes a message with the given payload to the topic and returns the sequence
+// number of the message.
+func (t *Topic) Publish(ctx context.Context, payload string) (uint64, error) {
+	for {
+		val, version, err := t.storage.GetValue(ctx, t.key)
+		if err != nil {
+			return 0, err
+		}
+		record, err := decodeRecord(val, version)
+		if err != nil {
+			return 0, err
+		}
+		seq := record.LastSeq + 1
+		record.LastSeq = seq
+		record.Messages = append(record.Messages, entry{
+			Seq:     seq,
+			Payload: payload,
+		})
+		if n := len(record.Messages) - t.options.BufferSize; n > 0 {
+			record.Messages = record.Messages[n:]
+		}
+		val, err = record.Encode()
+		if err != nil {
+			return 0, err
+		}
+		var newVersion versionedkv.Version
+		if version == nil {
+			newVersion, err = t.storage.CreateValue(ctx, t.key, val)
+		} else {
+			newVersion, err = t.storage.UpdateValue(ctx, t.key, val, version)
+		}
+		if err != nil {
+			return 0, err
+		}
+		if newVersion != nil {
+			return seq, nil
+		}
+	}
+}
+
+// Subscribe creates a new subscription to the topic, which receives messages published
+// after the subscription.
+func (t *Topic) Subscribe(ctx context.Context) (*Subscription, error) {
+	val, version, err := t.storage.GetValue(ctx, t.key)
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeRecord(val, version)
+	if err != nil {
+		return nil, err
+	}
+	return &Subscription{
+		topic:   t,
+		version: version,
+		lastSeq: record.LastSeq,
+	}, nil
+}
+
+// Subscription represents a subscription to a topic.
+type Subscription struct {
+	topic           *Topic
+	version         versionedkv.Version
+	lastSeq         uint64
+	pendingMessages []Message
+}
+
+// Next returns the next message of the subscription. If there is no such message, it blocks
+// until one is published or the given context is done.
+func (s *Subscription) Next(ctx context.Context) (Message, error) {
+	for len(s.pendingMessages) == 0 {
+		val, newVersion, err := s.topic.storage.WaitForValue(ctx, s.topic.key, s.version)
+		if err != nil {
+			return Message{}, err
+		}
+		s.version = newVersion
+		if newVersion == nil {
+			// The topic has been deleted, wait for it to be recreated.
+			s.lastSeq = 0
+			continue
+		}
+		record, err := decodeRecord(val, newVersion)
+		if err != nil {
+			return Message{}, err
+		}
+		if record.LastSeq < s.lastSeq {
+			// The topic has been recreated in between.
+			s.lastSeq = 0
+		}
+		for _, entry := range record.Messages {
+			if entry.Seq <= s.lastSeq {
+				continue
+			}
+			s.pendingMessages = append(s.pendingMessages, Message{
+				Seq:     entry.Seq,
+				Payload: entry.Payload,
+				Missed:  entry.Seq - s.lastSeq - 1,
+			})
+			s.lastSeq = entry.Seq
+		}
+	}
+	message := s.pendingMessages[0]
+	s.pendingMessages = s.pendingMessages[1:]
+	return message, nil
+}
+
+type record struct {
+	LastSeq  uint64  `json:"last_seq"`
+	Messages []entry `json:"messages"`
+}
+
+type entry struct {
+	Seq     uint64 `json:"seq"`
+	Payload string `json:"payload"`
+}
+
+func decodeRecord(val string, version versionedkv.Version) (record, error) {
+	var record record
+	if version != nil {
+		if err := json.Unmarshal([]byte(val), &record); err != nil {
+			return record, fmt.Errorf("pubsub: malformed record: %w", err)
+		}
+	}
+	return record, nil
+}
+
+func (r *record) Encode() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
@@ -0,0 +1,103 @@
+package pubsub_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/pubsub"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubscription_Next(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	topic := New(s, "foo", Options{BufferSize: 3})
+	ctx := context.Background()
+
+	_, err := topic.Publish(ctx, "before")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sub, err := topic.Subscribe(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for i := 0; i < 3; i++ {
+		_, err := topic.Publish(ctx, strconv.Itoa(i))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	for i := 0; i < 3; i++ {
+		m, err := sub.Next(ctx)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, Message{Seq: uint64(i + 2), Payload: strconv.Itoa(i)}, m)
+	}
+
+	// Messages evicted from the buffer are reported as missed.
+	for i := 3; i < 8; i++ {
+		_, err := topic.Publish(ctx, strconv.Itoa(i))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	m, err := sub.Next(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, Message{Seq: 7, Payload: "5", Missed: 2}, m)
+
+	ctx2, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	for i := 6; i < 8; i++ {
+		m, err = sub.Next(ctx2)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, strconv.Itoa(i), m.Payload)
+	}
+	_, err = sub.Next(ctx2)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestSubscription_Next_Concurrently(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	const N = 100
+	topic := New(s, "foo", Options{BufferSize: N})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		sub, err := topic.Subscribe(ctx)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < N; i++ {
+				m, err := sub.Next(ctx)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, Message{Seq: uint64(i + 1), Payload: strconv.Itoa(i)}, m)
+			}
+		}()
+	}
+	for i := 0; i < N; i++ {
+		_, err := topic.Publish(ctx, strconv.Itoa(i))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	wg.Wait()
+}
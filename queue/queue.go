@@ -0,0 +1,278 @@
+// Package queue provides a work queue with at-least-once delivery on top of versionedkv.
+//
+// All messages of a queue are kept in the value of a single key. Claims are made with
+// version-conditioned updates, so a message is never claimed twice at the same time, and
+// idle consumers block on WaitForValue until there is something to claim. A claimed message
+// becomes visible again if it is neither acknowledged nor negatively acknowledged within
+// the visibility timeout.
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for queues.
+type Options struct {
+	// KeyPrefix is prepended to queue names to form keys.
+	// The default value is "queue/".
+	KeyPrefix string
+
+	// VisibilityTimeout is the duration a claimed message stays invisible to other consumers.
+	// The default value is 30 seconds.
+	VisibilityTimeout time.Duration
+}
+
+func (o *Options) sanitize() {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "queue/"
+	}
+	if o.VisibilityTimeout <= 0 {
+		o.VisibilityTimeout = 30 * time.Second
+	}
+}
+
+// Message represents a message claimed from a queue.
+type Message struct {
+	ID      string
+	Payload string
+
+	// Attempts is the number of times the message has been claimed, including the current one.
+	Attempts int
+}
+
+// Queue represents a work queue.
+type Queue struct {
+	storage versionedkv.Storage
+	key     string
+	options Options
+}
+
+// New creates a new queue with the given name on the given storage.
+func New(storage versionedkv.Storage, name string, options Options) *Queue {
+	options.sanitize()
+	return &Queue{
+		storage: storage,
+		key:     options.KeyPrefix + name,
+		options: options,
+	}
+}
+
+// Enqueue appends a message with the given payload to the queue and returns the id of the message.
+func (q *Queue) Enqueue(ctx context.Context, payload string) (string, error) {
+	var id string
+	if err := q.modify(ctx, func(record *record, _ time.Time) bool {
+		record.LastID++
+		id = strconv.FormatInt(record.LastID, 10)
+		record.Messages = append(record.Messages, entry{
+			ID:      id,
+			Payload: payload,
+		})
+		return true
+	}); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Dequeue claims the first visible message of the queue. If there is no such message, it
+// blocks until one becomes visible or the given context is done.
+func (q *Queue) Dequeue(ctx context.Context) (Message, error) {
+	for {
+		val, version, err := q.storage.GetValue(ctx, q.key)
+		if err != nil {
+			return Message{}, err
+		}
+		record, err := decodeRecord(val, version)
+		if err != nil {
+			return Message{}, err
+		}
+		now := time.Now()
+		i := record.FirstVisibleMessage(now)
+		if i >= 0 {
+			entry := &record.Messages[i]
+			entry.InvisibleUntil = now.Add(q.options.VisibilityTimeout).UnixNano()
+			entry.Attempts++
+			message := Message{
+				ID:       entry.ID,
+				Payload:  entry.Payload,
+				Attempts: entry.Attempts,
+			}
+			ok, err := q.save(ctx, &record, version)
+			if err != nil {
+				return Message{}, err
+			}
+			if ok {
+				return message, nil
+			}
+			// Someone else modified the queue in between, start over.
+			continue
+		}
+		nextVisibleAt := record.NextVisibleAt()
+		waitCtx, cancel := ctx, context.CancelFunc(func() {})
+		if !nextVisibleAt.IsZero() {
+			waitCtx, cancel = context.WithDeadline(ctx, nextVisibleAt)
+		}
+		_, _, err = q.storage.WaitForValue(waitCtx, q.key, version)
+		cancel()
+		if err != nil {
+			if ctx.Err() != nil {
+				return Message{}, ctx.Err()
+			}
+			if err != context.DeadlineExceeded {
+				return Message{}, err
+			}
+		}
+	}
+}
+
+// Ack acknowledges the given message, removing it from the queue.
+//
+// If the claim of the message has been lost, due to the visibility timeout for example,
+// ErrClaimLost is returned.
+func (q *Queue) Ack(ctx context.Context, message Message) error {
+	return q.settle(ctx, message, func(record *record, i int) {
+		record.Messages = append(record.Messages[:i], record.Messages[i+1:]...)
+	})
+}
+
+// Nack negatively acknowledges the given message, making it visible again right away.
+//
+// If the claim of the message has been lost, due to the visibility timeout for example,
+// ErrClaimLost is returned.
+func (q *Queue) Nack(ctx context.Context, message Message) error {
+	return q.settle(ctx, message, func(record *record, i int) {
+		record.Messages[i].InvisibleUntil = 0
+	})
+}
+
+func (q *Queue) settle(ctx context.Context, message Message, settler func(*record, int)) error {
+	var claimLost bool
+	if err := q.modify(ctx, func(record *record, now time.Time) bool {
+		i := record.FindMessage(message.ID)
+		claimLost = i < 0 ||
+			record.Messages[i].Attempts != message.Attempts ||
+			record.Messages[i].InvisibleUntil <= now.UnixNano()
+		if claimLost {
+			return false
+		}
+		settler(record, i)
+		return true
+	}); err != nil {
+		return err
+	}
+	if claimLost {
+		return fmt.Errorf("%w; messageID=%q", ErrClaimLost, message.ID)
+	}
+	return nil
+}
+
+func (q *Queue) modify(ctx context.Context, modifier func(*record, time.Time) bool) error {
+	for {
+		val, version, err := q.storage.GetValue(ctx, q.key)
+		if err != nil {
+			return err
+		}
+		record, err := decodeRecord(val, version)
+		if err != nil {
+			return err
+		}
+		if !modifier(&record, time.Now()) {
+			return nil
+		}
+		ok, err := q.save(ctx, &record, version)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+func (q *Queue) save(ctx context.Context, record *record, version versionedkv.Version) (bool, error) {
+	val, err := record.Encode()
+	if err != nil {
+		return false, err
+	}
+	var newVersion versionedkv.Version
+	if version == nil {
+		newVersion, err = q.storage.CreateValue(ctx, q.key, val)
+	} else {
+		newVersion, err = q.storage.UpdateValue(ctx, q.key, val, version)
+	}
+	if err != nil {
+		return false, err
+	}
+	return newVersion != nil, nil
+}
+
+// ErrClaimLost is returned when settling a message whose claim has been lost.
+var ErrClaimLost error = errors.New("queue: claim lost")
+
+type record struct {
+	LastID   int64   `json:"last_id"`
+	Messages []entry `json:"messages"`
+}
+
+type entry struct {
+	ID             string `json:"id"`
+	Payload        string `json:"payload"`
+	Attempts       int    `json:"attempts,omitempty"`
+	InvisibleUntil int64  `json:"invisible_until,omitempty"`
+}
+
+func decodeRecord(val string, version versionedkv.Version) (record, error) {
+	var record record
+	if version != nil {
+		if err := json.Unmarshal([]byte(val), &record); err != nil {
+			return record, fmt.Errorf("queue: malformed record: %w", err)
+		}
+	}
+	return record, nil
+}
+
+func (r *record) Encode() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (r *record) FirstVisibleMessage(now time.Time) int {
+	for i := range r.Messages {
+		if r.Messages[i].InvisibleUntil <= now.UnixNano() {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *record) NextVisibleAt() time.Time {
+	var nextVisibleAt int64
+	for i := range r.Messages {
+		if invisibleUntil := r.Messages[i].InvisibleUntil; nextVisibleAt == 0 || invisibleUntil < nextVisibleAt {
+			nextVisibleAt = invisibleUntil
+		}
+	}
+	if nextVisibleAt == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nextVisibleAt)
+}
+
+func (r *record) FindMessage(id string) int {
+	for i := range r.Messages {
+		if r.Messages[i].ID == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,136 @@
+package queue_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/queue"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueue_AckAndNack(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	q := New(s, "foo", Options{})
+	ctx := context.Background()
+
+	id1, err := q.Enqueue(ctx, "a")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	id2, err := q.Enqueue(ctx, "b")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NotEqual(t, id1, id2)
+
+	m1, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, Message{ID: id1, Payload: "a", Attempts: 1}, m1)
+	m2, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, Message{ID: id2, Payload: "b", Attempts: 1}, m2)
+
+	err = q.Nack(ctx, m1)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	m1, err = q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, Message{ID: id1, Payload: "a", Attempts: 2}, m1)
+
+	err = q.Ack(ctx, m1)
+	assert.NoError(t, err)
+	err = q.Ack(ctx, m1)
+	assert.True(t, errors.Is(err, ErrClaimLost))
+	err = q.Ack(ctx, m2)
+	assert.NoError(t, err)
+}
+
+func TestQueue_VisibilityTimeout(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	q := New(s, "foo", Options{VisibilityTimeout: 200 * time.Millisecond})
+	ctx := context.Background()
+
+	_, err := q.Enqueue(ctx, "a")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	m, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// Blocks until the claim expires.
+	m2, err := q.Dequeue(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, m.ID, m2.ID)
+	assert.Equal(t, 2, m2.Attempts)
+
+	err = q.Ack(ctx, m)
+	assert.True(t, errors.Is(err, ErrClaimLost))
+	err = q.Ack(ctx, m2)
+	assert.NoError(t, err)
+}
+
+func TestQueue_Dequeue(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	q := New(s, "foo", Options{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := q.Dequeue(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	const N = 50
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	payloads := make(map[string]int)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			for {
+				m, err := q.Dequeue(ctx)
+				if err != nil {
+					assert.Equal(t, context.DeadlineExceeded, err)
+					return
+				}
+				mu.Lock()
+				payloads[m.Payload]++
+				mu.Unlock()
+				assert.NoError(t, q.Ack(context.Background(), m))
+			}
+		}()
+	}
+	for i := 0; i < N; i++ {
+		_, err := q.Enqueue(context.Background(), strconv.Itoa(i))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	wg.Wait()
+	assert.Len(t, payloads, N)
+	for payload, n := range payloads {
+		assert.Equal(t, 1, n, payload)
+	}
+}
@@ -0,0 +1,254 @@
+// Package quotastorage provides a decorator of versionedkv enforcing quotas on the number
+// of keys and the total size of values per namespace.
+//
+// Usage is tracked incrementally from successful writes and deletes made through the
+// decorator, starting from an empty storage; call Recount to pick up values which already
+// exist. Writes to the same key are serialized, so the usage tracked stays exact under
+// concurrency.
+package quotastorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Limit represents the limits of a namespace.
+type Limit struct {
+	// MaxKeys is the maximum number of keys.
+	// There is no limit if 0.
+	MaxKeys int
+
+	// MaxBytes is the maximum total size of values in bytes.
+	// There is no limit if 0.
+	MaxBytes int64
+}
+
+// Usage represents the usage of a namespace.
+type Usage struct {
+	Keys  int
+	Bytes int64
+}
+
+// Options represents options for quota storages.
+type Options struct {
+	// Namespace returns the namespace the given key belongs to.
+	// The default value returns the part of the key before the first slash, or an empty
+	// string if there is no slash.
+	Namespace func(key string) (namespace string)
+
+	// Limits maps namespaces to their limits.
+	Limits map[string]Limit
+
+	// DefaultLimit is the limit of namespaces not in Limits.
+	DefaultLimit Limit
+}
+
+func (o *Options) sanitize() {
+	if o.Namespace == nil {
+		o.Namespace = func(key string) string {
+			if i := strings.IndexByte(key, '/'); i >= 0 {
+				return key[:i]
+			}
+			return ""
+		}
+	}
+}
+
+// QuotaStorage is a storage enforcing quotas on another storage.
+type QuotaStorage struct {
+	storage versionedkv.Storage
+	options Options
+
+	keyLocks [64]sync.Mutex
+
+	mu         sync.Mutex
+	valueSizes map[string]int
+	usages     map[string]Usage
+}
+
+var _ versionedkv.Storage = (*QuotaStorage)(nil)
+
+// New creates a new storage enforcing quotas on the given storage.
+func New(storage versionedkv.Storage, options Options) *QuotaStorage {
+	options.sanitize()
+	return &QuotaStorage{
+		storage:    storage,
+		options:    options,
+		valueSizes: make(map[string]int),
+		usages:     make(map[string]Usage),
+	}
+}
+
+// Usage returns the usage of the given namespace.
+func (qs *QuotaStorage) Usage(namespace string) Usage {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	return qs.usages[namespace]
+}
+
+// Recount resets the usage tracked to that of the values in the storage, as reported by
+// Inspect.
+func (qs *QuotaStorage) Recount(ctx context.Context) error {
+	details, err := qs.storage.Inspect(ctx)
+	if err != nil {
+		return err
+	}
+	valueSizes := make(map[string]int, len(details.Values))
+	usages := make(map[string]Usage)
+	for key, valueDetails := range details.Values {
+		valueSizes[key] = len(valueDetails.V)
+		namespace := qs.options.Namespace(key)
+		usage := usages[namespace]
+		usage.Keys++
+		usage.Bytes += int64(len(valueDetails.V))
+		usages[namespace] = usage
+	}
+	qs.mu.Lock()
+	qs.valueSizes = valueSizes
+	qs.usages = usages
+	qs.mu.Unlock()
+	return nil
+}
+
+// GetValue implements versionedkv.Storage.GetValue.
+func (qs *QuotaStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	return qs.storage.GetValue(ctx, key)
+}
+
+// WaitForValue implements versionedkv.Storage.WaitForValue.
+func (qs *QuotaStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	return qs.storage.WaitForValue(ctx, key, oldVersion)
+}
+
+// CreateValue implements versionedkv.Storage.CreateValue.
+func (qs *QuotaStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	return qs.write(key, val, func() (versionedkv.Version, error) {
+		return qs.storage.CreateValue(ctx, key, val)
+	})
+}
+
+// UpdateValue implements versionedkv.Storage.UpdateValue.
+func (qs *QuotaStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	return qs.write(key, val, func() (versionedkv.Version, error) {
+		return qs.storage.UpdateValue(ctx, key, val, oldVersion)
+	})
+}
+
+// CreateOrUpdateValue implements versionedkv.Storage.CreateOrUpdateValue.
+func (qs *QuotaStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	return qs.write(key, val, func() (versionedkv.Version, error) {
+		return qs.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+	})
+}
+
+// DeleteValue implements versionedkv.Storage.DeleteValue.
+func (qs *QuotaStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	keyLock := qs.lockKey(key)
+	defer keyLock.Unlock()
+	ok, err := qs.storage.DeleteValue(ctx, key, version)
+	if err != nil || !ok {
+		return ok, err
+	}
+	qs.mu.Lock()
+	if valueSize, ok := qs.valueSizes[key]; ok {
+		delete(qs.valueSizes, key)
+		namespace := qs.options.Namespace(key)
+		usage := qs.usages[namespace]
+		usage.Keys--
+		usage.Bytes -= int64(valueSize)
+		qs.setUsage(namespace, usage)
+	}
+	qs.mu.Unlock()
+	return true, nil
+}
+
+// Close implements versionedkv.Storage.Close.
+func (qs *QuotaStorage) Close() error {
+	return qs.storage.Close()
+}
+
+// Inspect implements versionedkv.Storage.Inspect.
+func (qs *QuotaStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	return qs.storage.Inspect(ctx)
+}
+
+// Capabilities implements versionedkv.CapabilityReporter.Capabilities, forwarding the
+// capabilities of the storage decorated.
+func (qs *QuotaStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(qs.storage)
+}
+
+func (qs *QuotaStorage) write(key, val string, write func() (versionedkv.Version, error)) (versionedkv.Version, error) {
+	keyLock := qs.lockKey(key)
+	defer keyLock.Unlock()
+	namespace := qs.options.Namespace(key)
+	// Reserve the usage the write would take if it succeeded.
+	qs.mu.Lock()
+	oldValueSize, keyExists := qs.valueSizes[key]
+	usageDelta := Usage{Bytes: int64(len(val) - oldValueSize)}
+	if !keyExists {
+		usageDelta.Keys = 1
+	}
+	usage := qs.usages[namespace]
+	newUsage := Usage{Keys: usage.Keys + usageDelta.Keys, Bytes: usage.Bytes + usageDelta.Bytes}
+	if err := qs.checkLimit(namespace, usage, newUsage); err != nil {
+		qs.mu.Unlock()
+		return nil, err
+	}
+	qs.setUsage(namespace, newUsage)
+	qs.mu.Unlock()
+	newVersion, err := write()
+	qs.mu.Lock()
+	if err != nil || newVersion == nil {
+		usage := qs.usages[namespace]
+		usage.Keys -= usageDelta.Keys
+		usage.Bytes -= usageDelta.Bytes
+		qs.setUsage(namespace, usage)
+	} else {
+		qs.valueSizes[key] = len(val)
+	}
+	qs.mu.Unlock()
+	return newVersion, err
+}
+
+func (qs *QuotaStorage) checkLimit(namespace string, usage Usage, newUsage Usage) error {
+	limit, ok := qs.options.Limits[namespace]
+	if !ok {
+		limit = qs.options.DefaultLimit
+	}
+	if limit.MaxKeys > 0 && newUsage.Keys > usage.Keys && newUsage.Keys > limit.MaxKeys {
+		return fmt.Errorf("%w: too many keys; namespace=%q maxKeys=%d", ErrQuotaExceeded, namespace, limit.MaxKeys)
+	}
+	if limit.MaxBytes > 0 && newUsage.Bytes > usage.Bytes && newUsage.Bytes > limit.MaxBytes {
+		return fmt.Errorf("%w: too many bytes; namespace=%q maxBytes=%d", ErrQuotaExceeded, namespace, limit.MaxBytes)
+	}
+	return nil
+}
+
+func (qs *QuotaStorage) setUsage(namespace string, usage Usage) {
+	if usage == (Usage{}) {
+		delete(qs.usages, namespace)
+		return
+	}
+	qs.usages[namespace] = usage
+}
+
+func (qs *QuotaStorage) lockKey(key string) *sync.Mutex {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	keyLock := &qs.keyLocks[h.Sum32()%uint32(len(qs.keyLocks))]
+	keyLock.Lock()
+	return keyLock
+}
+
+// ErrQuotaExceeded is returned when a write would exceed the quota of a namespace.
+var ErrQuotaExceeded error = errors.New("quotastorage: quota exceeded")
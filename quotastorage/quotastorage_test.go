@@ -0,0 +1,118 @@
+package quotastorage_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/quotastorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuotaStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), Options{DefaultLimit: Limit{MaxKeys: 100}}), nil
+	})
+}
+
+func TestQuotaStorage_Limits(t *testing.T) {
+	t.Parallel()
+	s := New(memorystorage.New(), Options{
+		Limits: map[string]Limit{
+			"a": {MaxKeys: 2},
+			"b": {MaxBytes: 10},
+		},
+	})
+	defer s.Close()
+	ctx := context.Background()
+
+	_, err := s.CreateValue(ctx, "a/1", "123")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "a/2", "123")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "a/3", "123")
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	// Updating existing keys does not take more keys.
+	_, err = s.CreateOrUpdateValue(ctx, "a/2", "1234", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Keys: 2, Bytes: 7}, s.Usage("a"))
+	_, err = s.DeleteValue(ctx, "a/1", nil)
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "a/3", "123")
+	assert.NoError(t, err)
+
+	_, err = s.CreateValue(ctx, "b/1", "12345678")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "b/2", "123")
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+	// Shrinking values is always allowed.
+	_, err = s.UpdateValue(ctx, "b/1", "1", nil)
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "b/2", "123")
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Keys: 2, Bytes: 4}, s.Usage("b"))
+
+	// Failed writes do not take usage.
+	version, err := s.UpdateValue(ctx, "b/3", "123", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, version)
+	assert.Equal(t, Usage{Keys: 2, Bytes: 4}, s.Usage("b"))
+
+	// Other namespaces are unlimited.
+	_, err = s.CreateValue(ctx, "c", "1234567890abc")
+	assert.NoError(t, err)
+	assert.Equal(t, Usage{Keys: 1, Bytes: 13}, s.Usage(""))
+}
+
+func TestQuotaStorage_Concurrently(t *testing.T) {
+	t.Parallel()
+	s := New(memorystorage.New(), Options{DefaultLimit: Limit{MaxKeys: 10}})
+	defer s.Close()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var n int
+	for i := 0; i < 50; i++ {
+		key := "x/" + strconv.Itoa(i%20)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			version, err := s.CreateValue(context.Background(), key, "1")
+			if err != nil {
+				assert.True(t, errors.Is(err, ErrQuotaExceeded))
+				return
+			}
+			if version != nil {
+				mu.Lock()
+				n++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, n)
+	assert.Equal(t, Usage{Keys: 10, Bytes: 10}, s.Usage("x"))
+}
+
+func TestQuotaStorage_Recount(t *testing.T) {
+	t.Parallel()
+	ms := memorystorage.New()
+	defer ms.Close()
+	ctx := context.Background()
+	_, err := ms.CreateValue(ctx, "a/1", "123")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	s := New(ms, Options{DefaultLimit: Limit{MaxKeys: 1}})
+	assert.Equal(t, Usage{}, s.Usage("a"))
+	err = s.Recount(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, Usage{Keys: 1, Bytes: 3}, s.Usage("a"))
+	_, err = s.CreateValue(ctx, "a/2", "123")
+	assert.True(t, errors.Is(err, ErrQuotaExceeded))
+}
@@ -0,0 +1,10 @@
+package ratelimitstorage
+
+import "github.com/go-tk/versionedkv"
+
+func NumberOfCallerBuckets(s versionedkv.Storage) int {
+	rls := s.(*rateLimitStorage)
+	rls.mu.Lock()
+	defer rls.mu.Unlock()
+	return len(rls.callerBuckets)
+}
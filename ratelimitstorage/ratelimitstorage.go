@@ -0,0 +1,280 @@
+// Package ratelimitstorage provides a decorator of versionedkv rate-limiting operations
+// with token buckets per operation, per key prefix and per caller.
+package ratelimitstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Limit represents the limit of a token bucket.
+type Limit struct {
+	// Rate is the number of tokens added to the bucket per second.
+	Rate float64
+
+	// Burst is the capacity of the bucket.
+	// The capacity is 1 if the burst is less than 1.
+	Burst int
+}
+
+func (l *Limit) sanitize() {
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+}
+
+// Options represents options for rate-limit storages.
+type Options struct {
+	// OperationLimits maps operation names, e.g. versionedkv.OperationGetValue, to the limits
+	// of buckets shared by all calls of the operations.
+	// There is no limit per operation if the rate is 0, the same as with CallerLimit.
+	OperationLimits map[string]Limit
+
+	// KeyPrefixLimits maps key prefixes to the limits of buckets shared by all calls on keys
+	// with the prefixes. A call takes a token from the bucket of every prefix its key has.
+	// There is no limit per key prefix if the rate is 0, the same as with CallerLimit.
+	KeyPrefixLimits map[string]Limit
+
+	// CallerLimit is the limit of the bucket each caller has. The buckets of callers which
+	// have been idle long enough to be full again are evicted, so that memory does not grow
+	// with the number of callers seen.
+	// There is no limit per caller if the rate is 0.
+	CallerLimit Limit
+
+	// Caller returns the identity of the caller from the given context.
+	// The default value returns an empty string, which makes all calls share one bucket.
+	Caller func(ctx context.Context) (caller string)
+}
+
+func (o *Options) sanitize() {
+	o.CallerLimit.sanitize()
+	if o.Caller == nil {
+		o.Caller = func(context.Context) string { return "" }
+	}
+}
+
+// RateLimitedError is returned when an operation is rate-limited.
+type RateLimitedError struct {
+	// Scope describes the bucket running out of tokens, e.g. `operation "UpdateValue"`,
+	// `key prefix "foo/"` or `caller "alice"`.
+	Scope string
+
+	// RetryAfter is the duration after which the bucket will have a token.
+	RetryAfter time.Duration
+}
+
+// Error implements error.Error.
+func (rle *RateLimitedError) Error() string {
+	return fmt.Sprintf("%v; scope=%s retryAfter=%v", ErrRateLimited, rle.Scope, rle.RetryAfter)
+}
+
+// Is tells whether the given error is ErrRateLimited.
+func (rle *RateLimitedError) Is(err error) bool {
+	return err == ErrRateLimited
+}
+
+// ErrRateLimited is the error every RateLimitedError is, for use with errors.Is.
+var ErrRateLimited error = errors.New("ratelimitstorage: rate limited")
+
+// New creates a new storage rate-limiting operations on the given storage.
+func New(storage versionedkv.Storage, options Options) versionedkv.Storage {
+	options.sanitize()
+	rls := &rateLimitStorage{
+		storage:          storage,
+		options:          options,
+		operationBuckets: make(map[string]*bucket),
+		keyPrefixBuckets: make(map[string]*bucket),
+		callerBuckets:    make(map[string]*bucket),
+	}
+	now := time.Now()
+	for operation, limit := range options.OperationLimits {
+		if limit.Rate > 0 {
+			limit.sanitize()
+			rls.operationBuckets[operation] = newBucket(limit, now)
+		}
+	}
+	for keyPrefix, limit := range options.KeyPrefixLimits {
+		if limit.Rate > 0 {
+			limit.sanitize()
+			rls.keyPrefixBuckets[keyPrefix] = newBucket(limit, now)
+		}
+	}
+	rls.lastCallerBucketEviction = now
+	return rls
+}
+
+type rateLimitStorage struct {
+	storage versionedkv.Storage
+	options Options
+
+	mu                       sync.Mutex
+	operationBuckets         map[string]*bucket
+	keyPrefixBuckets         map[string]*bucket
+	callerBuckets            map[string]*bucket
+	lastCallerBucketEviction time.Time
+}
+
+func (rls *rateLimitStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	if err := rls.take(ctx, versionedkv.OperationGetValue, key); err != nil {
+		return "", nil, err
+	}
+	return rls.storage.GetValue(ctx, key)
+}
+
+func (rls *rateLimitStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	if err := rls.take(ctx, versionedkv.OperationWaitForValue, key); err != nil {
+		return "", nil, err
+	}
+	return rls.storage.WaitForValue(ctx, key, oldVersion)
+}
+
+func (rls *rateLimitStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	if err := rls.take(ctx, versionedkv.OperationCreateValue, key); err != nil {
+		return nil, err
+	}
+	return rls.storage.CreateValue(ctx, key, val)
+}
+
+func (rls *rateLimitStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := rls.take(ctx, versionedkv.OperationUpdateValue, key); err != nil {
+		return nil, err
+	}
+	return rls.storage.UpdateValue(ctx, key, val, oldVersion)
+}
+
+func (rls *rateLimitStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := rls.take(ctx, versionedkv.OperationCreateOrUpdateValue, key); err != nil {
+		return nil, err
+	}
+	return rls.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+}
+
+func (rls *rateLimitStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	if err := rls.take(ctx, versionedkv.OperationDeleteValue, key); err != nil {
+		return false, err
+	}
+	return rls.storage.DeleteValue(ctx, key, version)
+}
+
+func (rls *rateLimitStorage) Close() error {
+	return rls.storage.Close()
+}
+
+func (rls *rateLimitStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	return rls.storage.Inspect(ctx)
+}
+
+func (rls *rateLimitStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(rls.storage)
+}
+
+func (rls *rateLimitStorage) take(ctx context.Context, operation string, key string) error {
+	type scopedBucket struct {
+		scope  string
+		bucket *bucket
+	}
+	var scopedBuckets []scopedBucket
+	now := time.Now()
+	rls.mu.Lock()
+	defer rls.mu.Unlock()
+	if bucket, ok := rls.operationBuckets[operation]; ok {
+		scopedBuckets = append(scopedBuckets, scopedBucket{fmt.Sprintf("operation %q", operation), bucket})
+	}
+	for keyPrefix, bucket := range rls.keyPrefixBuckets {
+		if strings.HasPrefix(key, keyPrefix) {
+			scopedBuckets = append(scopedBuckets, scopedBucket{fmt.Sprintf("key prefix %q", keyPrefix), bucket})
+		}
+	}
+	if rls.options.CallerLimit.Rate > 0 {
+		rls.evictCallerBuckets(now)
+		caller := rls.options.Caller(ctx)
+		bucket, ok := rls.callerBuckets[caller]
+		if !ok {
+			bucket = newBucket(rls.options.CallerLimit, now)
+			rls.callerBuckets[caller] = bucket
+		}
+		scopedBuckets = append(scopedBuckets, scopedBucket{fmt.Sprintf("caller %q", caller), bucket})
+	}
+	// Take tokens only if all buckets have them, so a rejected call costs nothing.
+	var rateLimitedError *RateLimitedError
+	for _, scopedBucket := range scopedBuckets {
+		scopedBucket.bucket.Refill(now)
+		if retryAfter := scopedBucket.bucket.RetryAfter(); retryAfter > 0 &&
+			(rateLimitedError == nil || retryAfter > rateLimitedError.RetryAfter) {
+			rateLimitedError = &RateLimitedError{
+				Scope:      scopedBucket.scope,
+				RetryAfter: retryAfter,
+			}
+		}
+	}
+	if rateLimitedError != nil {
+		return rateLimitedError
+	}
+	for _, scopedBucket := range scopedBuckets {
+		scopedBucket.bucket.Take()
+	}
+	return nil
+}
+
+// evictCallerBuckets evicts the buckets of callers which are full, since they are the same
+// as new ones, once per duration a bucket takes to fill up.
+func (rls *rateLimitStorage) evictCallerBuckets(now time.Time) {
+	limit := rls.options.CallerLimit
+	interval := time.Duration(float64(limit.Burst) / limit.Rate * float64(time.Second))
+	if now.Sub(rls.lastCallerBucketEviction) < interval {
+		return
+	}
+	rls.lastCallerBucketEviction = now
+	for caller, bucket := range rls.callerBuckets {
+		bucket.Refill(now)
+		if bucket.IsFull() {
+			delete(rls.callerBuckets, caller)
+		}
+	}
+}
+
+type bucket struct {
+	limit      Limit
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newBucket(limit Limit, now time.Time) *bucket {
+	return &bucket{
+		limit:      limit,
+		tokens:     float64(limit.Burst),
+		lastRefill: now,
+	}
+}
+
+func (b *bucket) Refill(now time.Time) {
+	if elapsed := now.Sub(b.lastRefill); elapsed > 0 {
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+elapsed.Seconds()*b.limit.Rate)
+		b.lastRefill = now
+	}
+}
+
+func (b *bucket) IsFull() bool {
+	return b.tokens >= float64(b.limit.Burst)
+}
+
+func (b *bucket) RetryAfter() time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - b.tokens) / b.limit.Rate * float64(time.Second)))
+}
+
+func (b *bucket) Take() {
+	b.tokens--
+}
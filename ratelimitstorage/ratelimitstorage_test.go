@@ -0,0 +1,132 @@
+package ratelimitstorage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/ratelimitstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), Options{CallerLimit: Limit{Rate: 1e9, Burst: 1e9}}), nil
+	})
+}
+
+type callerKey struct{}
+
+func TestRateLimitStorage_Limits(t *testing.T) {
+	t.Parallel()
+	s := New(memorystorage.New(), Options{
+		OperationLimits: map[string]Limit{
+			versionedkv.OperationCreateValue: {Rate: 1, Burst: 3},
+		},
+		KeyPrefixLimits: map[string]Limit{
+			"hot/": {Rate: 1, Burst: 1},
+		},
+		CallerLimit: Limit{Rate: 1, Burst: 2},
+		Caller: func(ctx context.Context) string {
+			caller, _ := ctx.Value(callerKey{}).(string)
+			return caller
+		},
+	})
+	defer s.Close()
+	alice := context.WithValue(context.Background(), callerKey{}, "alice")
+	bob := context.WithValue(context.Background(), callerKey{}, "bob")
+
+	assertRateLimited := func(err error, scope string) {
+		t.Helper()
+		var rateLimitedError *RateLimitedError
+		if !assert.True(t, errors.As(err, &rateLimitedError)) {
+			return
+		}
+		assert.True(t, errors.Is(err, ErrRateLimited))
+		assert.Equal(t, scope, rateLimitedError.Scope)
+		assert.True(t, rateLimitedError.RetryAfter > 0 && rateLimitedError.RetryAfter <= time.Second,
+			rateLimitedError.RetryAfter)
+	}
+
+	// Per key prefix.
+	_, _, err := s.GetValue(alice, "hot/1")
+	assert.NoError(t, err)
+	_, _, err = s.GetValue(bob, "hot/2")
+	assertRateLimited(err, `key prefix "hot/"`)
+
+	// Per caller.
+	_, _, err = s.GetValue(alice, "a")
+	assert.NoError(t, err)
+	_, _, err = s.GetValue(alice, "a")
+	assertRateLimited(err, `caller "alice"`)
+
+	// Per operation; rejected calls take no tokens from other buckets.
+	_, err = s.CreateValue(bob, "b1", "1")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(bob, "b2", "1")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(bob, "b3", "1")
+	assertRateLimited(err, `caller "bob"`)
+	ctx := context.WithValue(context.Background(), callerKey{}, "carol")
+	_, err = s.CreateValue(ctx, "c1", "1")
+	assert.NoError(t, err)
+	_, err = s.CreateValue(ctx, "c2", "1")
+	assertRateLimited(err, `operation "CreateValue"`)
+	_, _, err = s.GetValue(ctx, "c1")
+	assert.NoError(t, err)
+
+	// Tokens are refilled over time.
+	time.Sleep(time.Second)
+	_, _, err = s.GetValue(bob, "hot/2")
+	assert.NoError(t, err)
+
+	// Inspect is not limited.
+	_, err = s.Inspect(alice)
+	assert.NoError(t, err)
+}
+
+func TestRateLimitStorage_ZeroRates(t *testing.T) {
+	t.Parallel()
+	s := New(memorystorage.New(), Options{
+		OperationLimits: map[string]Limit{
+			versionedkv.OperationGetValue: {Rate: 0, Burst: 1},
+		},
+		KeyPrefixLimits: map[string]Limit{
+			"": {Rate: 0},
+		},
+	})
+	defer s.Close()
+	for i := 0; i < 10; i++ {
+		_, _, err := s.GetValue(context.Background(), "foo")
+		assert.NoError(t, err)
+	}
+}
+
+func TestRateLimitStorage_CallerBucketEviction(t *testing.T) {
+	t.Parallel()
+	s := New(memorystorage.New(), Options{
+		CallerLimit: Limit{Rate: 100, Burst: 1},
+		Caller: func(ctx context.Context) string {
+			caller, _ := ctx.Value(callerKey{}).(string)
+			return caller
+		},
+	})
+	defer s.Close()
+	for i := 0; i < 100; i++ {
+		ctx := context.WithValue(context.Background(), callerKey{}, fmt.Sprintf("caller%d", i))
+		_, _, err := s.GetValue(ctx, "foo")
+		assert.NoError(t, err)
+	}
+	assert.True(t, NumberOfCallerBuckets(s) >= 1)
+
+	// Buckets of idle callers are full again after 10ms, and evicted.
+	time.Sleep(50 * time.Millisecond)
+	ctx := context.WithValue(context.Background(), callerKey{}, "alice")
+	_, _, err := s.GetValue(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, NumberOfCallerBuckets(s))
+}
@@ -0,0 +1,54 @@
+package versionedkv
+
+import (
+	"context"
+	"errors"
+)
+
+// StorageReader represents the read-only part of a storage.
+type StorageReader interface {
+	// GetValue is the same as Storage.GetValue.
+	GetValue(ctx context.Context, key string) (value string, version Version, err error)
+
+	// WaitForValue is the same as Storage.WaitForValue.
+	WaitForValue(ctx context.Context, key string, oldVersion Version) (value string, newVersion Version, err error)
+
+	// Inspect is the same as Storage.Inspect.
+	Inspect(ctx context.Context) (details StorageDetails, err error)
+}
+
+var _ StorageReader = Storage(nil)
+
+// ReadOnly returns a read-only view of the given storage. GetValue, WaitForValue and Inspect
+// pass through to the given storage, while the other methods, including Close, fail with
+// ErrReadOnly. Closing the given storage is left to its owner.
+func ReadOnly(storage StorageReader) Storage {
+	return readOnlyStorage{storage}
+}
+
+type readOnlyStorage struct {
+	StorageReader
+}
+
+func (readOnlyStorage) CreateValue(context.Context, string, string) (Version, error) {
+	return nil, ErrReadOnly
+}
+
+func (readOnlyStorage) UpdateValue(context.Context, string, string, Version) (Version, error) {
+	return nil, ErrReadOnly
+}
+
+func (readOnlyStorage) CreateOrUpdateValue(context.Context, string, string, Version) (Version, error) {
+	return nil, ErrReadOnly
+}
+
+func (readOnlyStorage) DeleteValue(context.Context, string, Version) (bool, error) {
+	return false, ErrReadOnly
+}
+
+func (readOnlyStorage) Close() error {
+	return ErrReadOnly
+}
+
+// ErrReadOnly is returned when modifying a read-only storage.
+var ErrReadOnly error = errors.New("versionedkv: storage read-only")
@@ -0,0 +1,270 @@
+package referencestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/stretchr/testify/assert"
+)
+
+// Step represents a step of a trace, which is an operation to perform on storages.
+type Step struct {
+	// Operation is the name of the operation, e.g. versionedkv.OperationGetValue.
+	Operation string
+
+	// Key is the key given.
+	Key string
+
+	// Value is the value given to CreateValue, UpdateValue and CreateOrUpdateValue.
+	Value string
+
+	// Version selects the version given to WaitForValue, UpdateValue, CreateOrUpdateValue
+	// and DeleteValue among those returned by the previous steps: nil if 0 or no version
+	// has been returned yet, otherwise the ((Version-1) mod N)-th of the N versions
+	// returned so far.
+	Version int
+}
+
+// String returns a readable representation of the step.
+func (s Step) String() string {
+	switch s.Operation {
+	case versionedkv.OperationGetValue:
+		return fmt.Sprintf("%s(%q)", s.Operation, s.Key)
+	case versionedkv.OperationWaitForValue, versionedkv.OperationDeleteValue:
+		return fmt.Sprintf("%s(%q, #%d)", s.Operation, s.Key, s.Version)
+	case versionedkv.OperationCreateValue:
+		return fmt.Sprintf("%s(%q, %q)", s.Operation, s.Key, s.Value)
+	case versionedkv.OperationUpdateValue, versionedkv.OperationCreateOrUpdateValue:
+		return fmt.Sprintf("%s(%q, %q, #%d)", s.Operation, s.Key, s.Value, s.Version)
+	default:
+		return s.Operation + "()"
+	}
+}
+
+// RandomTrace returns a random trace with the given number of steps on a few keys,
+// generated from the given seed.
+func RandomTrace(seed int64, numberOfSteps int) []Step {
+	random := rand.New(rand.NewSource(seed))
+	keys := [...]string{"foo", "bar", "baz"}
+	operations := [...]string{
+		versionedkv.OperationGetValue,
+		versionedkv.OperationWaitForValue,
+		versionedkv.OperationCreateValue,
+		versionedkv.OperationUpdateValue,
+		versionedkv.OperationCreateOrUpdateValue,
+		versionedkv.OperationDeleteValue,
+	}
+	trace := make([]Step, numberOfSteps)
+	for i := range trace {
+		step := &trace[i]
+		if i == numberOfSteps-1 && random.Intn(2) == 0 {
+			step.Operation = versionedkv.OperationClose
+			continue
+		}
+		step.Operation = operations[random.Intn(len(operations))]
+		step.Key = keys[random.Intn(len(keys))]
+		step.Value = strconv.Itoa(i + 1)
+		if random.Intn(4) != 0 {
+			step.Version = 1 + random.Intn(8)
+		}
+	}
+	return trace
+}
+
+// Compare performs the steps of the given trace on the given reference storage and the
+// given candidate storage in turn, and compares their results and the states reported by
+// Inspect after each step. Versions are compared by the steps which first returned them,
+// since versions of different storages are not comparable. It returns a *Mismatch for
+// the first step on which the storages disagree.
+//
+// WaitForValue is given a short timeout, so that it does not block forever on steps
+// where it should block.
+func Compare(reference, candidate versionedkv.Storage, trace []Step) error {
+	referenceRunner := runner{storage: reference}
+	candidateRunner := runner{storage: candidate}
+	for i, step := range trace {
+		expectedResult := referenceRunner.Perform(step)
+		result := candidateRunner.Perform(step)
+		if result != expectedResult {
+			return &Mismatch{Trace: trace[:i+1], Expected: expectedResult, Actual: result}
+		}
+		expectedState := referenceRunner.Inspect()
+		state := candidateRunner.Inspect()
+		if state != expectedState {
+			return &Mismatch{Trace: trace[:i+1], IsState: true, Expected: expectedState, Actual: state}
+		}
+	}
+	return nil
+}
+
+// Mismatch is returned by Compare when the storages disagree.
+type Mismatch struct {
+	// Trace is the trace up to and including the step on which the storages disagree.
+	Trace []Step
+
+	// IsState tells whether the storages disagree on the states reported by Inspect
+	// rather than on the results of the step.
+	IsState bool
+
+	// Expected and Actual are the readable representations of the results or states of
+	// the reference storage and the candidate storage, where versions are written as "vN"
+	// for the version first returned by the N-th step.
+	Expected string
+	Actual   string
+}
+
+// Error implements error.Error.
+func (m *Mismatch) Error() string {
+	var builder strings.Builder
+	what := "result"
+	if m.IsState {
+		what = "state"
+	}
+	fmt.Fprintf(&builder, "referencestorage: %s mismatch at step %d; expected=%s actual=%s\ntrace:",
+		what, len(m.Trace), m.Expected, m.Actual)
+	for i, step := range m.Trace {
+		fmt.Fprintf(&builder, "\n\t%d. %v", i+1, step)
+	}
+	return builder.String()
+}
+
+type runner struct {
+	storage       versionedkv.Storage
+	numberOfSteps int
+	versions      []versionedkv.Version
+	versionLabels []string
+	versionsGiven []versionedkv.Version
+}
+
+func (r *runner) Perform(step Step) string {
+	r.numberOfSteps++
+	ctx := context.Background()
+	var version versionedkv.Version
+	if step.Version >= 1 && len(r.versionsGiven) >= 1 {
+		version = r.versionsGiven[(step.Version-1)%len(r.versionsGiven)]
+	}
+	var result string
+	var err error
+	switch step.Operation {
+	case versionedkv.OperationGetValue:
+		var value string
+		var version2 versionedkv.Version
+		value, version2, err = r.storage.GetValue(ctx, step.Key)
+		result = strconv.Quote(value) + ", " + r.formatVersionReturned(version2)
+	case versionedkv.OperationWaitForValue:
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		var value string
+		var newVersion versionedkv.Version
+		value, newVersion, err = r.storage.WaitForValue(ctx, step.Key, version)
+		cancel()
+		result = strconv.Quote(value) + ", " + r.formatVersionReturned(newVersion)
+	case versionedkv.OperationCreateValue:
+		var newVersion versionedkv.Version
+		newVersion, err = r.storage.CreateValue(ctx, step.Key, step.Value)
+		result = r.formatVersionReturned(newVersion)
+	case versionedkv.OperationUpdateValue:
+		var newVersion versionedkv.Version
+		newVersion, err = r.storage.UpdateValue(ctx, step.Key, step.Value, version)
+		result = r.formatVersionReturned(newVersion)
+	case versionedkv.OperationCreateOrUpdateValue:
+		var newVersion versionedkv.Version
+		newVersion, err = r.storage.CreateOrUpdateValue(ctx, step.Key, step.Value, version)
+		result = r.formatVersionReturned(newVersion)
+	case versionedkv.OperationDeleteValue:
+		var ok bool
+		ok, err = r.storage.DeleteValue(ctx, step.Key, version)
+		result = strconv.FormatBool(ok)
+	case versionedkv.OperationClose:
+		err = r.storage.Close()
+	default:
+		panic("unknown operation: " + step.Operation)
+	}
+	if err != nil {
+		return formatError(err)
+	}
+	return result
+}
+
+// formatVersionReturned is like labelVersion, and makes the given version, unless nil,
+// available to the following steps.
+func (r *runner) formatVersionReturned(version versionedkv.Version) string {
+	if version != nil {
+		r.versionsGiven = append(r.versionsGiven, version)
+	}
+	return r.labelVersion(version)
+}
+
+func (r *runner) Inspect() string {
+	details, err := r.storage.Inspect(context.Background())
+	if err != nil {
+		return formatError(err)
+	}
+	keys := make([]string, 0, len(details.Values))
+	for key := range details.Values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "{isClosed: %v, numberOfWatchers: %d, values: {", details.IsClosed, details.NumberOfWatchers)
+	for i, key := range keys {
+		if i >= 1 {
+			builder.WriteString(", ")
+		}
+		valueDetails := details.Values[key]
+		fmt.Fprintf(&builder, "%q: %q %s", key, valueDetails.V, r.labelVersion(valueDetails.Version))
+	}
+	builder.WriteString("}}")
+	return builder.String()
+}
+
+// labelVersion returns "vN" for the given version, if the N-th step is the first one
+// which returned it.
+func (r *runner) labelVersion(version versionedkv.Version) string {
+	if version == nil {
+		return "nil"
+	}
+	for i, version2 := range r.versions {
+		if reflect.DeepEqual(version2, version) {
+			return r.versionLabels[i]
+		}
+	}
+	versionLabel := "v" + strconv.Itoa(r.numberOfSteps)
+	r.versions = append(r.versions, version)
+	r.versionLabels = append(r.versionLabels, versionLabel)
+	return versionLabel
+}
+
+func formatError(err error) string {
+	for err2 := errors.Unwrap(err); err2 != nil; err, err2 = err2, errors.Unwrap(err2) {
+	}
+	return "error: " + err.Error()
+}
+
+// DoTestStorageAgainstReference tests storages created by the given storage factory by
+// comparing them with reference storages on random traces, one per seed from 1 to the
+// given number of seeds.
+func DoTestStorageAgainstReference(t *testing.T, sf versionedkv.StorageFactory, numberOfSeeds int) {
+	for seed := int64(1); seed <= int64(numberOfSeeds); seed++ {
+		seed := seed
+		t.Run(fmt.Sprintf("Seed=%d", seed), func(t *testing.T) {
+			t.Parallel()
+			s, err := sf()
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			defer s.Close()
+			rs := New()
+			defer rs.Close()
+			assert.NoError(t, Compare(rs, s, RandomTrace(seed, 100)))
+		})
+	}
+}
@@ -0,0 +1,196 @@
+// Package referencestorage provides a reference implementation of versionedkv, which is
+// trivially correct rather than fast: a map guarded by a single mutex, and a broadcast
+// channel waking all the watchers on every change.
+//
+// It is meant as an oracle for testing other implementations, see
+// DoTestStorageAgainstReference.
+package referencestorage
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// New creates a new reference storage.
+func New() versionedkv.Storage {
+	return &referenceStorage{
+		values:  make(map[string]value),
+		changes: make(chan struct{}),
+		closure: make(chan struct{}),
+	}
+}
+
+type referenceStorage struct {
+	mu               sync.Mutex
+	values           map[string]value
+	lastVersion      uint64
+	changes          chan struct{}
+	numberOfWatchers int
+	isClosed         bool
+	closure          chan struct{}
+}
+
+type value struct {
+	V       string
+	Version uint64
+}
+
+func (rs *referenceStorage) GetValue(_ context.Context, key string) (string, versionedkv.Version, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return "", nil, versionedkv.ErrStorageClosed
+	}
+	value, ok := rs.values[key]
+	if !ok {
+		return "", nil, nil
+	}
+	return value.V, value.Version, nil
+}
+
+func (rs *referenceStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	rs.mu.Lock()
+	for {
+		if rs.isClosed {
+			rs.mu.Unlock()
+			return "", nil, versionedkv.ErrStorageClosed
+		}
+		value, ok := rs.values[key]
+		if !ok && oldVersion != nil {
+			rs.mu.Unlock()
+			return "", nil, nil
+		}
+		if ok && (oldVersion == nil || oldVersion != versionedkv.Version(value.Version)) {
+			rs.mu.Unlock()
+			return value.V, value.Version, nil
+		}
+		changes := rs.changes
+		rs.numberOfWatchers++
+		rs.mu.Unlock()
+		var err error
+		select {
+		case <-changes:
+		case <-rs.closure:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		rs.mu.Lock()
+		rs.numberOfWatchers--
+		if err != nil {
+			rs.mu.Unlock()
+			return "", nil, err
+		}
+	}
+}
+
+func (rs *referenceStorage) CreateValue(_ context.Context, key, val string) (versionedkv.Version, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return nil, versionedkv.ErrStorageClosed
+	}
+	if _, ok := rs.values[key]; ok {
+		return nil, nil
+	}
+	return rs.setValue(key, val), nil
+}
+
+func (rs *referenceStorage) UpdateValue(_ context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return nil, versionedkv.ErrStorageClosed
+	}
+	value, ok := rs.values[key]
+	if !ok || (oldVersion != nil && oldVersion != versionedkv.Version(value.Version)) {
+		return nil, nil
+	}
+	return rs.setValue(key, val), nil
+}
+
+func (rs *referenceStorage) CreateOrUpdateValue(_ context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return nil, versionedkv.ErrStorageClosed
+	}
+	value, ok := rs.values[key]
+	if ok && oldVersion != nil && oldVersion != versionedkv.Version(value.Version) {
+		return nil, nil
+	}
+	return rs.setValue(key, val), nil
+}
+
+func (rs *referenceStorage) DeleteValue(_ context.Context, key string, version versionedkv.Version) (bool, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return false, versionedkv.ErrStorageClosed
+	}
+	value, ok := rs.values[key]
+	if !ok || (version != nil && version != versionedkv.Version(value.Version)) {
+		return false, nil
+	}
+	delete(rs.values, key)
+	rs.notifyChanges()
+	return true, nil
+}
+
+func (rs *referenceStorage) Close() error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return versionedkv.ErrStorageClosed
+	}
+	rs.isClosed = true
+	close(rs.closure)
+	return nil
+}
+
+func (rs *referenceStorage) Inspect(_ context.Context) (versionedkv.StorageDetails, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.isClosed {
+		return versionedkv.StorageDetails{IsClosed: true}, nil
+	}
+	var valueDetails map[string]versionedkv.ValueDetails
+	for key, value := range rs.values {
+		if valueDetails == nil {
+			valueDetails = make(map[string]versionedkv.ValueDetails, len(rs.values))
+		}
+		valueDetails[key] = versionedkv.ValueDetails{
+			V:       value.V,
+			Version: value.Version,
+		}
+	}
+	return versionedkv.StorageDetails{
+		Values:           valueDetails,
+		NumberOfWatchers: rs.numberOfWatchers,
+	}, nil
+}
+
+func (*referenceStorage) Capabilities() []versionedkv.Capability {
+	return []versionedkv.Capability{
+		versionedkv.CapabilityBinaryValues,
+		versionedkv.CapabilityWatcherCounting,
+		versionedkv.CapabilityLeakChecking,
+		versionedkv.CapabilityLinearizability,
+	}
+}
+
+func (rs *referenceStorage) setValue(key, val string) versionedkv.Version {
+	rs.lastVersion++
+	rs.values[key] = value{V: val, Version: rs.lastVersion}
+	rs.notifyChanges()
+	return rs.lastVersion
+}
+
+func (rs *referenceStorage) notifyChanges() {
+	close(rs.changes)
+	rs.changes = make(chan struct{})
+}
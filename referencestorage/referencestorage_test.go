@@ -0,0 +1,59 @@
+package referencestorage_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/referencestorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReferenceStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(), nil
+	})
+}
+
+func TestDoTestStorageAgainstReference(t *testing.T) {
+	DoTestStorageAgainstReference(t, func() (versionedkv.Storage, error) {
+		return memorystorage.New(), nil
+	}, 20)
+}
+
+type sloppyStorage struct {
+	versionedkv.Storage
+}
+
+// DeleteValue ignores the version given.
+func (ss sloppyStorage) DeleteValue(ctx context.Context, key string, _ versionedkv.Version) (bool, error) {
+	return ss.Storage.DeleteValue(ctx, key, nil)
+}
+
+func TestCompare(t *testing.T) {
+	t.Parallel()
+	trace := []Step{
+		{Operation: versionedkv.OperationCreateValue, Key: "foo", Value: "1"},
+		{Operation: versionedkv.OperationUpdateValue, Key: "foo", Value: "2", Version: 1},
+		{Operation: versionedkv.OperationGetValue, Key: "foo"},
+		{Operation: versionedkv.OperationDeleteValue, Key: "foo", Version: 1},
+		{Operation: versionedkv.OperationWaitForValue, Key: "foo", Version: 2},
+		{Operation: versionedkv.OperationClose},
+	}
+	err := Compare(New(), memorystorage.New(), trace)
+	assert.NoError(t, err)
+
+	err = Compare(New(), sloppyStorage{memorystorage.New()}, trace)
+	var mismatch *Mismatch
+	if !assert.True(t, errors.As(err, &mismatch)) {
+		t.FailNow()
+	}
+	assert.Equal(t, Mismatch{
+		Trace:    trace[:4],
+		Expected: "false",
+		Actual:   "true",
+	}, *mismatch)
+	assert.Contains(t, err.Error(), `4. DeleteValue("foo", #1)`)
+}
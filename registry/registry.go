@@ -0,0 +1,345 @@
+// Package registry provides service registration and discovery on top of versionedkv.
+//
+// All instances of a service are kept in the value of a single key, so the registry works
+// on any storage with nothing more than version-based compare-and-swap. Each registered
+// instance is refreshed by heartbeats and is considered gone once its TTL elapses without
+// a heartbeat.
+//
+// Clients can watch the alive instances of a service with a Resolver, which is shaped after
+// gRPC name resolvers but does not implement google.golang.org/grpc/resolver.Builder, so
+// that this package does not depend on gRPC; gRPC clients need the few lines of glue shown
+// in the documentation of ClientConn.
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for registries.
+type Options struct {
+	// KeyPrefix is prepended to service names to form keys.
+	// The default value is "registry/".
+	KeyPrefix string
+
+	// TTL is the duration an instance stays alive without a heartbeat.
+	// The default value is 10 seconds.
+	TTL time.Duration
+
+	// HeartbeatInterval is the interval between heartbeats of a registration.
+	// The default value is TTL / 3.
+	HeartbeatInterval time.Duration
+}
+
+func (o *Options) sanitize() {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "registry/"
+	}
+	if o.TTL <= 0 {
+		o.TTL = 10 * time.Second
+	}
+	if o.HeartbeatInterval <= 0 {
+		o.HeartbeatInterval = o.TTL / 3
+	}
+}
+
+// Instance represents an instance of a service.
+type Instance struct {
+	ID       string
+	Address  string
+	Metadata map[string]string
+}
+
+// Registry registers and resolves instances of services.
+type Registry struct {
+	storage versionedkv.Storage
+	options Options
+}
+
+// New creates a new registry on the given storage.
+func New(storage versionedkv.Storage, options Options) *Registry {
+	options.sanitize()
+	return &Registry{
+		storage: storage,
+		options: options,
+	}
+}
+
+// Register adds the given instance to the given service and keeps it alive with heartbeats
+// until the returned registration is deregistered.
+func (r *Registry) Register(ctx context.Context, serviceName string, instance Instance) (*Registration, error) {
+	if instance.ID == "" {
+		return nil, errors.New("registry: empty instance id")
+	}
+	if err := r.refresh(ctx, serviceName, instance); err != nil {
+		return nil, err
+	}
+	registration := &Registration{
+		registry:    r,
+		serviceName: serviceName,
+		instance:    instance,
+		stop:        make(chan struct{}),
+	}
+	registration.wg.Add(1)
+	go registration.heartbeat()
+	return registration, nil
+}
+
+// Resolve returns the alive instances of the given service, sorted by id.
+func (r *Registry) Resolve(ctx context.Context, serviceName string) ([]Instance, error) {
+	val, version, err := r.storage.GetValue(ctx, r.key(serviceName))
+	if err != nil {
+		return nil, err
+	}
+	record, err := decodeRecord(val, version)
+	if err != nil {
+		return nil, err
+	}
+	instances, _ := record.AliveInstances(time.Now())
+	return instances, nil
+}
+
+// Watch calls the given callback with the alive instances of the given service, first with
+// the current ones and then every time they change. It blocks until the given context is
+// done or an error occurs.
+func (r *Registry) Watch(ctx context.Context, serviceName string, callback func(instances []Instance)) error {
+	key := r.key(serviceName)
+	val, version, err := r.storage.GetValue(ctx, key)
+	if err != nil {
+		return err
+	}
+	var lastInstances []Instance
+	for isFirst := true; ; isFirst = false {
+		record, err := decodeRecord(val, version)
+		if err != nil {
+			return err
+		}
+		instances, nextExpiry := record.AliveInstances(time.Now())
+		if isFirst || !instancesEqual(instances, lastInstances) {
+			callback(instances)
+			lastInstances = instances
+		}
+		for {
+			waitCtx, cancel := ctx, context.CancelFunc(func() {})
+			if !nextExpiry.IsZero() {
+				waitCtx, cancel = context.WithDeadline(ctx, nextExpiry)
+			}
+			newVal, newVersion, err := r.storage.WaitForValue(waitCtx, key, version)
+			cancel()
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				if err == context.DeadlineExceeded {
+					// Some instances expired without any write.
+					break
+				}
+				return err
+			}
+			if newVersion == nil && version == nil {
+				// The value still does not exist, keep waiting for its creation.
+				continue
+			}
+			val, version = newVal, newVersion
+			break
+		}
+	}
+}
+
+func (r *Registry) refresh(ctx context.Context, serviceName string, instance Instance) error {
+	expiresAt := time.Now().Add(r.options.TTL).UnixNano()
+	return r.modify(ctx, serviceName, func(record *record) {
+		record.Instances[instance.ID] = entry{
+			Address:   instance.Address,
+			Metadata:  instance.Metadata,
+			ExpiresAt: expiresAt,
+		}
+	})
+}
+
+func (r *Registry) remove(ctx context.Context, serviceName string, instanceID string) error {
+	return r.modify(ctx, serviceName, func(record *record) {
+		delete(record.Instances, instanceID)
+	})
+}
+
+func (r *Registry) modify(ctx context.Context, serviceName string, modifier func(*record)) error {
+	key := r.key(serviceName)
+	for {
+		val, version, err := r.storage.GetValue(ctx, key)
+		if err != nil {
+			return err
+		}
+		record, err := decodeRecord(val, version)
+		if err != nil {
+			return err
+		}
+		record.RemoveExpiredInstances(time.Now())
+		modifier(&record)
+		if len(record.Instances) == 0 {
+			if version == nil {
+				return nil
+			}
+			ok, err := r.storage.DeleteValue(ctx, key, version)
+			if err != nil {
+				return err
+			}
+			if ok {
+				return nil
+			}
+			continue
+		}
+		val, err = record.Encode()
+		if err != nil {
+			return err
+		}
+		var newVersion versionedkv.Version
+		if version == nil {
+			newVersion, err = r.storage.CreateValue(ctx, key, val)
+		} else {
+			newVersion, err = r.storage.UpdateValue(ctx, key, val, version)
+		}
+		if err != nil {
+			return err
+		}
+		if newVersion != nil {
+			return nil
+		}
+	}
+}
+
+func (r *Registry) key(serviceName string) string {
+	return r.options.KeyPrefix + serviceName
+}
+
+// Registration represents a registered instance of a service.
+type Registration struct {
+	registry    *Registry
+	serviceName string
+	instance    Instance
+	stop        chan struct{}
+	stopOnce    sync.Once
+	wg          sync.WaitGroup
+}
+
+// Instance returns the registered instance.
+func (r *Registration) Instance() Instance {
+	return r.instance
+}
+
+// Deregister stops heartbeats and removes the registered instance from the service.
+func (r *Registration) Deregister(ctx context.Context) error {
+	r.stopOnce.Do(func() { close(r.stop) })
+	r.wg.Wait()
+	return r.registry.remove(ctx, r.serviceName, r.instance.ID)
+}
+
+func (r *Registration) heartbeat() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.registry.options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.registry.options.HeartbeatInterval)
+		err := r.registry.refresh(ctx, r.serviceName, r.instance)
+		cancel()
+		if errors.Is(err, versionedkv.ErrStorageClosed) {
+			return
+		}
+		// Other errors are transient as far as we know, the next heartbeat will retry.
+	}
+}
+
+type record struct {
+	Instances map[string]entry `json:"instances"`
+}
+
+type entry struct {
+	Address   string            `json:"address"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	ExpiresAt int64             `json:"expires_at"`
+}
+
+func decodeRecord(val string, version versionedkv.Version) (record, error) {
+	var record record
+	if version != nil {
+		if err := json.Unmarshal([]byte(val), &record); err != nil {
+			return record, fmt.Errorf("registry: malformed record: %w", err)
+		}
+	}
+	if record.Instances == nil {
+		record.Instances = make(map[string]entry)
+	}
+	return record, nil
+}
+
+func (r *record) Encode() (string, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func (r *record) RemoveExpiredInstances(now time.Time) {
+	for id, entry := range r.Instances {
+		if entry.ExpiresAt <= now.UnixNano() {
+			delete(r.Instances, id)
+		}
+	}
+}
+
+func (r *record) AliveInstances(now time.Time) ([]Instance, time.Time) {
+	var instances []Instance
+	var nextExpiry int64
+	for id, entry := range r.Instances {
+		if entry.ExpiresAt <= now.UnixNano() {
+			continue
+		}
+		instances = append(instances, Instance{
+			ID:       id,
+			Address:  entry.Address,
+			Metadata: entry.Metadata,
+		})
+		if nextExpiry == 0 || entry.ExpiresAt < nextExpiry {
+			nextExpiry = entry.ExpiresAt
+		}
+	}
+	sort.Slice(instances, func(i, j int) bool { return instances[i].ID < instances[j].ID })
+	if nextExpiry == 0 {
+		return instances, time.Time{}
+	}
+	return instances, time.Unix(0, nextExpiry)
+}
+
+func instancesEqual(instances1, instances2 []Instance) bool {
+	if len(instances1) != len(instances2) {
+		return false
+	}
+	for i := range instances1 {
+		instance1, instance2 := &instances1[i], &instances2[i]
+		if instance1.ID != instance2.ID || instance1.Address != instance2.Address {
+			return false
+		}
+		if len(instance1.Metadata) != len(instance2.Metadata) {
+			return false
+		}
+		for k, v := range instance1.Metadata {
+			if v2, ok := instance2.Metadata[k]; !ok || v2 != v {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package registry_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/registry"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistry_Resolve(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	r := New(s, Options{})
+	ctx := context.Background()
+
+	instances, err := r.Resolve(ctx, "foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, instances)
+
+	reg1, err := r.Register(ctx, "foo", Instance{ID: "1", Address: "10.0.0.1:80"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	reg2, err := r.Register(ctx, "foo", Instance{ID: "2", Address: "10.0.0.2:80", Metadata: map[string]string{"zone": "a"}})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	instances, err = r.Resolve(ctx, "foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Instance{
+		{ID: "1", Address: "10.0.0.1:80"},
+		{ID: "2", Address: "10.0.0.2:80", Metadata: map[string]string{"zone": "a"}},
+	}, instances)
+
+	err = reg1.Deregister(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	instances, err = r.Resolve(ctx, "foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Instance{{ID: "2", Address: "10.0.0.2:80", Metadata: map[string]string{"zone": "a"}}}, instances)
+
+	err = reg2.Deregister(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	details, err := s.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, details.Values)
+}
+
+func TestRegistry_Heartbeat(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	ctx := context.Background()
+
+	r1 := New(s, Options{TTL: 200 * time.Millisecond})
+	reg, err := r1.Register(ctx, "foo", Instance{ID: "1"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer reg.Deregister(ctx)
+	// Without heartbeats, instances expire after TTL.
+	r2 := New(s, Options{TTL: 200 * time.Millisecond, HeartbeatInterval: time.Hour})
+	reg2, err := r2.Register(ctx, "foo", Instance{ID: "2"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer reg2.Deregister(ctx)
+
+	time.Sleep(500 * time.Millisecond)
+	instances, err := r1.Resolve(ctx, "foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Instance{{ID: "1"}}, instances)
+}
+
+func TestRegistry_Watch(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	r := New(s, Options{TTL: 300 * time.Millisecond, HeartbeatInterval: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	updates := make(chan []Instance, 10)
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- r.Watch(ctx, "foo", func(instances []Instance) { updates <- instances })
+	}()
+	assert.Empty(t, <-updates)
+
+	_, err := r.Register(ctx, "foo", Instance{ID: "1", Address: "a"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Instance{{ID: "1", Address: "a"}}, <-updates)
+	// The instance expires as no heartbeat is sent.
+	assert.Empty(t, <-updates)
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-watchErr)
+}
+
+func TestResolver(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	r := New(s, Options{})
+	ctx := context.Background()
+
+	cc := clientConn{updates: make(chan []Instance, 10)}
+	rb := NewResolverBuilder(r, "versionedkv")
+	assert.Equal(t, "versionedkv", rb.Scheme())
+	resolver := rb.Build("foo", &cc)
+	defer resolver.Close()
+	assert.Empty(t, <-cc.updates)
+
+	reg, err := r.Register(ctx, "foo", Instance{ID: "1", Address: "a"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Instance{{ID: "1", Address: "a"}}, <-cc.updates)
+
+	resolver.ResolveNow()
+	assert.Equal(t, []Instance{{ID: "1", Address: "a"}}, <-cc.updates)
+
+	err = reg.Deregister(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, <-cc.updates)
+}
+
+type clientConn struct {
+	updates chan []Instance
+}
+
+func (cc *clientConn) UpdateAddresses(instances []Instance) error {
+	cc.updates <- instances
+	return nil
+}
+
+func (cc *clientConn) ReportError(error) {}
@@ -0,0 +1,138 @@
+package registry
+
+import (
+	"context"
+	"sync"
+)
+
+// ClientConn is the callback interface the resolver reports resolved addresses to.
+//
+// It mirrors the part of google.golang.org/grpc/resolver.ClientConn the resolver needs,
+// so this package does not have to depend on gRPC. A gRPC resolver.Builder can be made
+// out of a ResolverBuilder with a few lines of glue:
+//
+//	type grpcBuilder struct{ *registry.ResolverBuilder }
+//
+//	func (b grpcBuilder) Build(target resolver.Target, cc resolver.ClientConn,
+//		_ resolver.BuildOptions) (resolver.Resolver, error) {
+//		r := b.ResolverBuilder.Build(target.Endpoint(), grpcClientConn{cc})
+//		return grpcResolver{r}, nil
+//	}
+//
+//	type grpcClientConn struct{ cc resolver.ClientConn }
+//
+//	func (c grpcClientConn) UpdateAddresses(instances []registry.Instance) error {
+//		addresses := make([]resolver.Address, len(instances))
+//		for i, instance := range instances {
+//			addresses[i] = resolver.Address{Addr: instance.Address}
+//		}
+//		return c.cc.UpdateState(resolver.State{Addresses: addresses})
+//	}
+//
+//	func (c grpcClientConn) ReportError(err error) { c.cc.ReportError(err) }
+//
+//	type grpcResolver struct{ *registry.Resolver }
+//
+//	func (r grpcResolver) ResolveNow(resolver.ResolveNowOptions) { r.Resolver.ResolveNow() }
+type ClientConn interface {
+	// UpdateAddresses is called with the alive instances every time they change.
+	UpdateAddresses(instances []Instance) error
+
+	// ReportError is called when resolving fails.
+	ReportError(err error)
+}
+
+// ResolverBuilder builds resolvers on a registry.
+type ResolverBuilder struct {
+	registry *Registry
+	scheme   string
+}
+
+// NewResolverBuilder creates a new resolver builder with the given scheme on the given registry.
+func NewResolverBuilder(registry *Registry, scheme string) *ResolverBuilder {
+	return &ResolverBuilder{
+		registry: registry,
+		scheme:   scheme,
+	}
+}
+
+// Scheme returns the scheme of the resolvers.
+func (rb *ResolverBuilder) Scheme() string {
+	return rb.scheme
+}
+
+// Build creates a new resolver watching the given service and reporting to the given
+// client connection until the resolver is closed.
+func (rb *ResolverBuilder) Build(serviceName string, cc ClientConn) *Resolver {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Resolver{
+		registry:    rb.registry,
+		serviceName: serviceName,
+		cc:          cc,
+		cancel:      cancel,
+		resolveNow:  make(chan struct{}, 1),
+	}
+	r.wg.Add(1)
+	go r.run(ctx)
+	return r
+}
+
+// Resolver keeps a client connection updated with the alive instances of a service.
+type Resolver struct {
+	registry    *Registry
+	serviceName string
+	cc          ClientConn
+	cancel      context.CancelFunc
+	resolveNow  chan struct{}
+	wg          sync.WaitGroup
+}
+
+// ResolveNow asks the resolver to re-resolve the service as soon as possible.
+func (r *Resolver) ResolveNow() {
+	select {
+	case r.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the resolver.
+func (r *Resolver) Close() {
+	r.cancel()
+	r.wg.Wait()
+}
+
+func (r *Resolver) run(ctx context.Context) {
+	defer r.wg.Done()
+	for {
+		watchCtx, cancel := context.WithCancel(ctx)
+		watchErr := make(chan error, 1)
+		go func() {
+			watchErr <- r.registry.Watch(watchCtx, r.serviceName, func(instances []Instance) {
+				if err := r.cc.UpdateAddresses(instances); err != nil {
+					r.cc.ReportError(err)
+				}
+			})
+		}()
+		var err error
+		select {
+		case err = <-watchErr:
+		case <-r.resolveNow:
+			// Restart watching, which reports the current instances again.
+			cancel()
+			<-watchErr
+		}
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.cc.ReportError(err)
+			// Wait for a re-resolution request rather than spinning on a broken storage.
+			select {
+			case <-r.resolveNow:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
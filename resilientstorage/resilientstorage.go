@@ -0,0 +1,283 @@
+// Package resilientstorage provides a decorator of versionedkv retrying transient errors
+// and opening a circuit after repeated failures, for storages on remote backends.
+//
+// Only operations that are safe to retry are retried: GetValue, WaitForValue, Inspect,
+// CreateValue, and UpdateValue, CreateOrUpdateValue and DeleteValue given versions. A
+// conditional write whose first attempt took effect without a response reaching the client
+// has its retry fail the condition, so it is never applied twice, though it may be reported
+// as a conflict. Unconditional writes are never retried.
+package resilientstorage
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for resilient storages.
+type Options struct {
+	// MaxAttempts is the maximum number of attempts of an operation safe to retry.
+	// The default value is 3.
+	MaxAttempts int
+
+	// MinBackoff is the upper bound of the delay before the first retry; the upper bound
+	// doubles with each retry, and the actual delay is chosen at random below it.
+	// The default value is 100 milliseconds.
+	MinBackoff time.Duration
+
+	// MaxBackoff caps the upper bound of the delay before a retry.
+	// The default value is 2 seconds.
+	MaxBackoff time.Duration
+
+	// IsTransient tells whether the given error is transient, i.e. whether the operation
+	// failing with the error may succeed if retried.
+	// The default value is IsTransient.
+	IsTransient func(err error) (isTransient bool)
+
+	// FailureThreshold is the number of consecutive transient errors which opens the circuit.
+	// The default value is 5.
+	FailureThreshold int
+
+	// OpenDuration is the duration the circuit stays open before letting a trial operation
+	// through. WaitForValue, which may block indefinitely, is never a trial, and a trial
+	// not finished within the duration is given up, letting another trial through.
+	// The default value is 10 seconds.
+	OpenDuration time.Duration
+
+	// Seed is the seed of the random number generator for jitters.
+	// The default value is the current time.
+	Seed int64
+}
+
+func (o *Options) sanitize() {
+	if o.MaxAttempts <= 0 {
+		o.MaxAttempts = 3
+	}
+	if o.MinBackoff <= 0 {
+		o.MinBackoff = 100 * time.Millisecond
+	}
+	if o.MaxBackoff <= 0 {
+		o.MaxBackoff = 2 * time.Second
+	}
+	if o.IsTransient == nil {
+		o.IsTransient = IsTransient
+	}
+	if o.FailureThreshold <= 0 {
+		o.FailureThreshold = 5
+	}
+	if o.OpenDuration <= 0 {
+		o.OpenDuration = 10 * time.Second
+	}
+	if o.Seed == 0 {
+		o.Seed = time.Now().UnixNano()
+	}
+}
+
+// New creates a new storage retrying and circuit-breaking operations on the given storage.
+func New(storage versionedkv.Storage, options Options) versionedkv.Storage {
+	options.sanitize()
+	return &resilientStorage{
+		storage: storage,
+		options: options,
+		rand:    rand.New(rand.NewSource(options.Seed)),
+	}
+}
+
+type resilientStorage struct {
+	storage versionedkv.Storage
+	options Options
+
+	mu                  sync.Mutex
+	rand                *rand.Rand
+	consecutiveFailures int
+	openUntil           time.Time
+	lastTrialID         uint64
+	trialID             uint64
+	trialDeadline       time.Time
+}
+
+func (rs *resilientStorage) GetValue(ctx context.Context, key string) (val string, version versionedkv.Version, err error) {
+	err = rs.do(ctx, true, true, func() (err error) {
+		val, version, err = rs.storage.GetValue(ctx, key)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (val string, newVersion versionedkv.Version, err error) {
+	err = rs.do(ctx, true, false, func() (err error) {
+		val, newVersion, err = rs.storage.WaitForValue(ctx, key, oldVersion)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) CreateValue(ctx context.Context, key, val string) (version versionedkv.Version, err error) {
+	err = rs.do(ctx, true, true, func() (err error) {
+		version, err = rs.storage.CreateValue(ctx, key, val)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (newVersion versionedkv.Version, err error) {
+	err = rs.do(ctx, oldVersion != nil, true, func() (err error) {
+		newVersion, err = rs.storage.UpdateValue(ctx, key, val, oldVersion)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (newVersion versionedkv.Version, err error) {
+	err = rs.do(ctx, oldVersion != nil, true, func() (err error) {
+		newVersion, err = rs.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) DeleteValue(ctx context.Context, key string,
+	version versionedkv.Version) (ok bool, err error) {
+	err = rs.do(ctx, version != nil, true, func() (err error) {
+		ok, err = rs.storage.DeleteValue(ctx, key, version)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) Close() error {
+	return rs.storage.Close()
+}
+
+func (rs *resilientStorage) Inspect(ctx context.Context) (details versionedkv.StorageDetails, err error) {
+	err = rs.do(ctx, true, true, func() (err error) {
+		details, err = rs.storage.Inspect(ctx)
+		return
+	})
+	return
+}
+
+func (rs *resilientStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(rs.storage)
+}
+
+func (rs *resilientStorage) do(ctx context.Context, isRetrySafe bool, canBeTrial bool, operation func() error) error {
+	for attempt := 1; ; attempt++ {
+		trialID, err := rs.enterCircuit(canBeTrial)
+		if err != nil {
+			return err
+		}
+		err = operation()
+		isTransient := err != nil && ctx.Err() == nil && rs.options.IsTransient(err)
+		rs.leaveCircuit(trialID, isTransient)
+		if !isTransient || !isRetrySafe || attempt == rs.options.MaxAttempts {
+			return err
+		}
+		timer := time.NewTimer(rs.backoff(attempt))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// enterCircuit returns the ID of the trial if the operation is let through as a trial,
+// otherwise 0.
+func (rs *resilientStorage) enterCircuit(canBeTrial bool) (uint64, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.openUntil.IsZero() {
+		return 0, nil
+	}
+	now := time.Now()
+	if now.Before(rs.openUntil) || !canBeTrial || (rs.trialID != 0 && now.Before(rs.trialDeadline)) {
+		return 0, ErrCircuitOpen
+	}
+	// Half-open: let one trial operation through, giving up the previous one if any.
+	rs.lastTrialID++
+	rs.trialID = rs.lastTrialID
+	rs.trialDeadline = now.Add(rs.options.OpenDuration)
+	return rs.trialID, nil
+}
+
+func (rs *resilientStorage) leaveCircuit(trialID uint64, isFailure bool) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !rs.openUntil.IsZero() {
+		// While the circuit is open, only the trial in progress decides the state of the
+		// circuit; operations let through before the circuit opened and trials given up
+		// are ignored.
+		if trialID == 0 || trialID != rs.trialID {
+			return
+		}
+		rs.trialID = 0
+		if isFailure {
+			rs.openUntil = time.Now().Add(rs.options.OpenDuration)
+			return
+		}
+		rs.consecutiveFailures = 0
+		rs.openUntil = time.Time{}
+		return
+	}
+	if !isFailure {
+		rs.consecutiveFailures = 0
+		rs.openUntil = time.Time{}
+		return
+	}
+	rs.consecutiveFailures++
+	if rs.consecutiveFailures >= rs.options.FailureThreshold {
+		rs.openUntil = time.Now().Add(rs.options.OpenDuration)
+	}
+}
+
+func (rs *resilientStorage) backoff(attempt int) time.Duration {
+	maxBackoff := rs.options.MinBackoff << (attempt - 1)
+	if maxBackoff > rs.options.MaxBackoff || maxBackoff <= 0 {
+		maxBackoff = rs.options.MaxBackoff
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return time.Duration(rs.rand.Int63n(int64(maxBackoff) + 1))
+}
+
+// ErrCircuitOpen is returned when operating on a storage whose circuit is open.
+var ErrCircuitOpen error = errors.New("resilientstorage: circuit open")
+
+// MarkTransient wraps the given error to mark it as transient.
+func MarkTransient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return transientError{err}
+}
+
+// IsTransient tells whether the given error is transient. An error is transient if it or
+// any error it wraps has been marked with MarkTransient, or has a method `Temporary() bool`
+// returning true, like net.Error.
+func IsTransient(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if _, ok := err.(transientError); ok {
+			return true
+		}
+		if temporaryError, ok := err.(interface{ Temporary() bool }); ok && temporaryError.Temporary() {
+			return true
+		}
+	}
+	return false
+}
+
+type transientError struct {
+	err error
+}
+
+func (te transientError) Error() string { return te.err.Error() }
+func (te transientError) Unwrap() error { return te.err }
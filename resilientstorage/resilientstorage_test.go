@@ -0,0 +1,218 @@
+package resilientstorage_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/resilientstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResilientStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), Options{}), nil
+	})
+}
+
+func TestResilientStorage_Retry(t *testing.T) {
+	t.Parallel()
+	fs := flakyStorage{Storage: memorystorage.New()}
+	s := New(&fs, Options{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond, FailureThreshold: 100})
+	defer s.Close()
+	ctx := context.Background()
+
+	fs.NumberOfFailures = 2
+	version, err := s.CreateValue(ctx, "foo", "1")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NotNil(t, version)
+	assert.Equal(t, 3, fs.NumberOfCalls)
+
+	fs.NumberOfCalls, fs.NumberOfFailures = 0, 3
+	_, _, err = s.GetValue(ctx, "foo")
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, 3, fs.NumberOfCalls)
+
+	// Unconditional writes are not retried.
+	fs.NumberOfCalls, fs.NumberOfFailures = 0, 1
+	_, err = s.UpdateValue(ctx, "foo", "2", nil)
+	assert.True(t, IsTransient(err))
+	assert.Equal(t, 1, fs.NumberOfCalls)
+
+	// Conditional writes are retried.
+	fs.NumberOfCalls, fs.NumberOfFailures = 0, 1
+	_, err = s.UpdateValue(ctx, "foo", "2", version)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, fs.NumberOfCalls)
+
+	// Non-transient errors are not retried.
+	fs.NumberOfCalls, fs.NumberOfFailures, fs.Err = 0, 1, errors.New("permanent")
+	_, _, err = s.GetValue(ctx, "foo")
+	assert.EqualError(t, err, "permanent")
+	assert.Equal(t, 1, fs.NumberOfCalls)
+}
+
+func TestResilientStorage_CircuitBreaker(t *testing.T) {
+	t.Parallel()
+	fs := flakyStorage{Storage: memorystorage.New()}
+	s := New(&fs, Options{
+		MaxAttempts:      1,
+		FailureThreshold: 2,
+		OpenDuration:     100 * time.Millisecond,
+	})
+	defer s.Close()
+	ctx := context.Background()
+
+	fs.NumberOfFailures = 2
+	for i := 0; i < 2; i++ {
+		_, _, err := s.GetValue(ctx, "foo")
+		assert.True(t, IsTransient(err))
+	}
+	_, _, err := s.GetValue(ctx, "foo")
+	assert.Equal(t, ErrCircuitOpen, err)
+	assert.Equal(t, 2, fs.NumberOfCalls)
+
+	time.Sleep(150 * time.Millisecond)
+	_, _, err = s.GetValue(ctx, "foo")
+	assert.NoError(t, err)
+	_, _, err = s.GetValue(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, fs.NumberOfCalls)
+}
+
+func TestResilientStorage_CircuitBreakerTrial(t *testing.T) {
+	t.Parallel()
+	bs := blockingStorage{
+		Storage: memorystorage.New(),
+		Blocks: map[string]chan error{
+			"slow":  make(chan error),
+			"fail1": make(chan error, 1),
+			"fail2": make(chan error, 1),
+			"hang":  make(chan error),
+		},
+	}
+	s := New(&bs, Options{
+		MaxAttempts:      1,
+		FailureThreshold: 2,
+		OpenDuration:     100 * time.Millisecond,
+	})
+	defer s.Close()
+	ctx := context.Background()
+	call := func(key string) chan error {
+		result := make(chan error, 1)
+		go func() {
+			_, _, err := s.GetValue(ctx, key)
+			result <- err
+		}()
+		return result
+	}
+
+	// Let an operation through before the circuit opens.
+	slowResult := call("slow")
+	time.Sleep(10 * time.Millisecond)
+	bs.Blocks["fail1"] <- MarkTransient(errors.New("blip"))
+	bs.Blocks["fail2"] <- MarkTransient(errors.New("blip"))
+	assert.True(t, IsTransient(<-call("fail1")))
+	assert.True(t, IsTransient(<-call("fail2")))
+	assert.Equal(t, ErrCircuitOpen, <-call("foo"))
+	time.Sleep(150 * time.Millisecond)
+
+	// WaitForValue, which may block indefinitely, is never a trial.
+	_, _, err := s.WaitForValue(ctx, "foo", nil)
+	assert.Equal(t, ErrCircuitOpen, err)
+
+	// The trial is in progress.
+	hangResult := call("hang")
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, ErrCircuitOpen, <-call("foo"))
+
+	// The operation let through before the circuit opened does not end the trial.
+	close(bs.Blocks["slow"])
+	assert.NoError(t, <-slowResult)
+	assert.Equal(t, ErrCircuitOpen, <-call("foo"))
+
+	// The trial not finished in time is given up, letting another trial through.
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, <-call("foo"))
+	assert.NoError(t, <-call("foo"))
+	close(bs.Blocks["hang"])
+	assert.NoError(t, <-hangResult)
+}
+
+func TestIsTransient(t *testing.T) {
+	t.Parallel()
+	assert.False(t, IsTransient(nil))
+	assert.False(t, IsTransient(errors.New("foo")))
+	assert.True(t, IsTransient(MarkTransient(errors.New("foo"))))
+	assert.True(t, IsTransient(fmt.Errorf("bar: %w", MarkTransient(errors.New("foo")))))
+	assert.True(t, IsTransient(temporaryError{}))
+	assert.Nil(t, MarkTransient(nil))
+}
+
+type flakyStorage struct {
+	versionedkv.Storage
+
+	NumberOfCalls    int
+	NumberOfFailures int
+	Err              error
+}
+
+func (fs *flakyStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	if err := fs.call(); err != nil {
+		return "", nil, err
+	}
+	return fs.Storage.GetValue(ctx, key)
+}
+
+func (fs *flakyStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	if err := fs.call(); err != nil {
+		return nil, err
+	}
+	return fs.Storage.CreateValue(ctx, key, val)
+}
+
+func (fs *flakyStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := fs.call(); err != nil {
+		return nil, err
+	}
+	return fs.Storage.UpdateValue(ctx, key, val, oldVersion)
+}
+
+func (fs *flakyStorage) call() error {
+	fs.NumberOfCalls++
+	if fs.NumberOfFailures == 0 {
+		return nil
+	}
+	fs.NumberOfFailures--
+	if fs.Err != nil {
+		return fs.Err
+	}
+	return MarkTransient(errors.New("blip"))
+}
+
+type temporaryError struct{}
+
+func (temporaryError) Error() string   { return "temporary" }
+func (temporaryError) Temporary() bool { return true }
+
+type blockingStorage struct {
+	versionedkv.Storage
+
+	Blocks map[string]chan error
+}
+
+func (bs *blockingStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	if block, ok := bs.Blocks[key]; ok {
+		if err := <-block; err != nil {
+			return "", nil, err
+		}
+	}
+	return bs.Storage.GetValue(ctx, key)
+}
@@ -0,0 +1,109 @@
+// Package sequence provides generators of unique increasing ids on top of versionedkv.
+//
+// A counter key holds the last allocated id. Generators allocate ids in blocks by
+// incrementing the counter with version-conditioned updates and hand out ids from the
+// cached block, so most ids cost no round trip to the storage. Ids are unique across all
+// generators sharing the counter and increase within a generator; ids left in the block of
+// a generator that goes away are never handed out, so set the block size to 1 if gaps
+// are unacceptable.
+package sequence
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for generators.
+type Options struct {
+	// KeyPrefix is prepended to sequence names to form keys.
+	// The default value is "sequence/".
+	KeyPrefix string
+
+	// BlockSize is the number of ids allocated at a time.
+	// The default value is 100.
+	BlockSize int
+}
+
+func (o *Options) sanitize() {
+	if o.KeyPrefix == "" {
+		o.KeyPrefix = "sequence/"
+	}
+	if o.BlockSize <= 0 {
+		o.BlockSize = 100
+	}
+}
+
+// Generator generates unique increasing ids.
+type Generator struct {
+	storage versionedkv.Storage
+	key     string
+	options Options
+
+	mu     sync.Mutex
+	nextID uint64
+	lastID uint64
+}
+
+// New creates a new generator for the sequence with the given name on the given storage.
+func New(storage versionedkv.Storage, name string, options Options) *Generator {
+	options.sanitize()
+	return &Generator{
+		storage: storage,
+		key:     options.KeyPrefix + name,
+		options: options,
+	}
+}
+
+// Next returns the next id, which is greater than 0.
+func (g *Generator) Next(ctx context.Context) (uint64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.nextID == 0 || g.nextID > g.lastID {
+		firstID, lastID, err := g.allocateBlock(ctx)
+		if err != nil {
+			return 0, err
+		}
+		g.nextID, g.lastID = firstID, lastID
+	}
+	id := g.nextID
+	g.nextID++
+	return id, nil
+}
+
+func (g *Generator) allocateBlock(ctx context.Context) (uint64, uint64, error) {
+	blockSize := uint64(g.options.BlockSize)
+	for {
+		val, version, err := g.storage.GetValue(ctx, g.key)
+		if err != nil {
+			return 0, 0, err
+		}
+		var lastID uint64
+		if version != nil {
+			lastID, err = strconv.ParseUint(val, 10, 64)
+			if err != nil {
+				return 0, 0, fmt.Errorf("sequence: malformed counter: %w", err)
+			}
+		}
+		newLastID := lastID + blockSize
+		if newLastID < lastID {
+			return 0, 0, fmt.Errorf("sequence: counter overflow; key=%q", g.key)
+		}
+		val = strconv.FormatUint(newLastID, 10)
+		var newVersion versionedkv.Version
+		if version == nil {
+			newVersion, err = g.storage.CreateValue(ctx, g.key, val)
+		} else {
+			newVersion, err = g.storage.UpdateValue(ctx, g.key, val, version)
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if newVersion != nil {
+			return lastID + 1, newLastID, nil
+		}
+	}
+}
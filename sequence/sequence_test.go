@@ -0,0 +1,73 @@
+package sequence_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/sequence"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerator_Next(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	g := New(s, "foo", Options{BlockSize: 3})
+	ctx := context.Background()
+
+	for i := uint64(1); i <= 7; i++ {
+		id, err := g.Next(ctx)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, i, id)
+	}
+	val, _, err := s.GetValue(ctx, "sequence/foo")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "9", val)
+
+	g2 := New(s, "foo", Options{BlockSize: 3})
+	id, err := g2.Next(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, uint64(10), id)
+}
+
+func TestGenerator_Next_Concurrently(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	const (
+		numberOfGenerators = 5
+		numberOfIDs        = 200
+	)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	ids := make(map[uint64]struct{})
+	for i := 0; i < numberOfGenerators; i++ {
+		g := New(s, "foo", Options{BlockSize: 7})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var lastID uint64
+			for j := 0; j < numberOfIDs; j++ {
+				id, err := g.Next(context.Background())
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Greater(t, id, lastID)
+				lastID = id
+				mu.Lock()
+				ids[id] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Len(t, ids, numberOfGenerators*numberOfIDs)
+}
@@ -0,0 +1,421 @@
+// Package simulation provides deterministic simulations of concurrent workloads on
+// versionedkv storages.
+//
+// A simulation runs tasks, which are functions called in goroutines of their own, one at
+// a time. A task runs until it reaches a scheduling point, i.e. an operation on the
+// storage of the simulation or a call to Yield or Sleep, then the scheduler picks the
+// next task to run at random. Time is virtual: it stands still while there are tasks
+// runnable, and jumps to the next timer, set by Sleep or WithTimeout, once all tasks are
+// blocked. Given the same seed and the same tasks, a simulation makes the same choices,
+// so that a failure can be replayed, and timeouts take no real time.
+//
+// WaitForValue on the storage of the simulation blocks only the task calling it: the task
+// is resumed once the value has been written by another task, the context given has been
+// canceled, or the storage has been closed. To stay deterministic, tasks must get the
+// time from Now and timeouts from WithTimeout rather than from the time package, e.g. to
+// expire leases or TTLs, and must not block on anything but the simulation.
+package simulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Options represents options for simulations.
+type Options struct {
+	// Seed is the seed of the random number generator making the choices of a simulation.
+	Seed int64
+
+	// StartTime is the virtual time a simulation starts at.
+	// The default value is 2000-01-01T00:00:00Z.
+	StartTime time.Time
+
+	// MaxSteps is the maximum number of steps, i.e. tasks resumed, of a simulation.
+	// The default value is 100000.
+	MaxSteps int
+}
+
+func (o *Options) sanitize() {
+	if o.StartTime.IsZero() {
+		o.StartTime = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if o.MaxSteps == 0 {
+		o.MaxSteps = 100000
+	}
+}
+
+// Simulation represents a simulation.
+type Simulation struct {
+	options Options
+	storage versionedkv.Storage
+	rand    *rand.Rand
+
+	now           time.Time
+	clock         int64
+	numberOfSteps int
+	tasks         []*task
+	timers        []*timer
+	nextTimerID   int
+	operations    []versionedkv.Operation
+	trace         []string
+	parked        chan struct{}
+	isAborted     bool
+	err           error
+}
+
+// New creates a new simulation of workloads on the given storage.
+func New(storage versionedkv.Storage, options Options) *Simulation {
+	options.sanitize()
+	s := &Simulation{
+		options: options,
+		rand:    rand.New(rand.NewSource(options.Seed)),
+		now:     options.StartTime,
+		parked:  make(chan struct{}),
+	}
+	s.storage = &simulatedStorage{s, storage}
+	return s
+}
+
+// Storage returns the storage of the simulation, whose operations called with the contexts
+// of tasks are scheduling points and are recorded. Operations called with other contexts,
+// e.g. after the simulation has ended, pass through unrecorded.
+func (s *Simulation) Storage() versionedkv.Storage {
+	return s.storage
+}
+
+// Go adds a task with the given name and function to the simulation. It can be called
+// before Run or from a running task.
+func (s *Simulation) Go(name string, f func(ctx context.Context)) {
+	s.tasks = append(s.tasks, &task{
+		simulation: s,
+		name:       name,
+		f:          f,
+		rand:       rand.New(rand.NewSource(s.rand.Int63())),
+		resume:     make(chan struct{}),
+	})
+}
+
+// Run runs the tasks until all of them have returned. It fails if the tasks are
+// deadlocked, if the maximum number of steps is reached, if a task panics, or if the
+// storage misbehaves by not returning from WaitForValue when expected to. On failure,
+// the tasks remaining are stopped.
+func (s *Simulation) Run() error {
+	for {
+		if s.err != nil {
+			s.abort()
+			return s.err
+		}
+		s.wakeCanceledWaiters()
+		var runnableTasks []*task
+		var blockedTaskNames []string
+		for _, task := range s.tasks {
+			switch task.state {
+			case taskRunnable:
+				runnableTasks = append(runnableTasks, task)
+			case taskSleeping, taskWaiting:
+				blockedTaskNames = append(blockedTaskNames, task.name)
+			}
+		}
+		if len(runnableTasks) == 0 {
+			if s.fireNextTimer() {
+				continue
+			}
+			if len(blockedTaskNames) == 0 {
+				return nil
+			}
+			s.err = fmt.Errorf("%w; blockedTasks=%q", ErrDeadlock, blockedTaskNames)
+			continue
+		}
+		if s.numberOfSteps == s.options.MaxSteps {
+			s.err = fmt.Errorf("%w; maxSteps=%d", ErrTooManySteps, s.options.MaxSteps)
+			continue
+		}
+		s.numberOfSteps++
+		s.runTask(runnableTasks[s.rand.Intn(len(runnableTasks))])
+	}
+}
+
+// Now returns the virtual time of the simulation.
+func (s *Simulation) Now() time.Time {
+	return s.now
+}
+
+// Operations returns the operations recorded, in the order they returned, for checks
+// such as versionedkv.CheckLinearizability.
+func (s *Simulation) Operations() []versionedkv.Operation {
+	return append([]versionedkv.Operation(nil), s.operations...)
+}
+
+// Trace returns the trace of the simulation, one line per operation recorded, which is
+// identical between runs with the same seed and tasks.
+func (s *Simulation) Trace() []string {
+	return append([]string(nil), s.trace...)
+}
+
+func (s *Simulation) runTask(task *task) {
+	if task.isStarted {
+		task.resume <- struct{}{}
+	} else {
+		task.isStarted = true
+		go task.run()
+	}
+	<-s.parked
+}
+
+func (s *Simulation) abort() {
+	s.isAborted = true
+	for _, task := range s.tasks {
+		if task.state == taskDone {
+			continue
+		}
+		if task.isStarted {
+			s.runTask(task)
+		}
+		task.state = taskDone
+	}
+}
+
+func (s *Simulation) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+func (s *Simulation) tick() int64 {
+	s.clock++
+	return s.clock
+}
+
+func (s *Simulation) addTimer(deadline time.Time, fire func()) *timer {
+	s.nextTimerID++
+	timer := &timer{
+		deadline: deadline,
+		id:       s.nextTimerID,
+		fire:     fire,
+	}
+	s.timers = append(s.timers, timer)
+	return timer
+}
+
+func (s *Simulation) fireNextTimer() bool {
+	timers := s.timers[:0]
+	for _, timer := range s.timers {
+		if !timer.isStopped {
+			timers = append(timers, timer)
+		}
+	}
+	s.timers = timers
+	if len(s.timers) == 0 {
+		return false
+	}
+	sort.Slice(s.timers, func(i, j int) bool {
+		if s.timers[i].deadline.Equal(s.timers[j].deadline) {
+			return s.timers[i].id < s.timers[j].id
+		}
+		return s.timers[i].deadline.Before(s.timers[j].deadline)
+	})
+	timer := s.timers[0]
+	s.timers = s.timers[1:]
+	if timer.deadline.After(s.now) {
+		s.now = timer.deadline
+	}
+	timer.fire()
+	return true
+}
+
+func (s *Simulation) wakeCanceledWaiters() {
+	for _, task := range s.tasks {
+		if task.state == taskWaiting && task.wait.ctx.Err() != nil {
+			s.collectWait(task)
+		}
+	}
+}
+
+func (s *Simulation) wakeWaiters(key string) {
+	for _, task := range s.tasks {
+		if task.state == taskWaiting && task.wait.key == key {
+			s.collectWait(task)
+		}
+	}
+}
+
+func (s *Simulation) wakeAllWaiters() {
+	for _, task := range s.tasks {
+		if task.state == taskWaiting {
+			s.collectWait(task)
+		}
+	}
+}
+
+// collectWait waits for the WaitForValue call of the given task to return, and makes the
+// task runnable.
+func (s *Simulation) collectWait(task *task) {
+	select {
+	case task.wait.result = <-task.wait.results:
+		task.state = taskRunnable
+	case <-time.After(wakeTimeout):
+		s.fail(fmt.Errorf("%w; task=%q key=%q", ErrWaiterNotReturning, task.name, task.wait.key))
+	}
+}
+
+const wakeTimeout = 5 * time.Second
+
+type taskState int
+
+const (
+	taskRunnable taskState = iota
+	taskSleeping
+	taskWaiting
+	taskDone
+)
+
+type task struct {
+	simulation *Simulation
+	name       string
+	f          func(ctx context.Context)
+	rand       *rand.Rand
+	resume     chan struct{}
+
+	isStarted bool
+	state     taskState
+	wait      *wait
+}
+
+type wait struct {
+	ctx     context.Context
+	key     string
+	results chan waitResult
+	result  waitResult
+}
+
+type waitResult struct {
+	Value      string
+	NewVersion versionedkv.Version
+	Err        error
+}
+
+func (t *task) run() {
+	s := t.simulation
+	defer func() {
+		if r := recover(); r != nil {
+			s.fail(fmt.Errorf("simulation: task panicked; task=%q panic=%v", t.name, r))
+		}
+		t.state = taskDone
+		s.parked <- struct{}{}
+	}()
+	t.f(context.WithValue(context.Background(), taskKey{}, t))
+}
+
+func (t *task) park(state taskState) {
+	s := t.simulation
+	t.state = state
+	s.parked <- struct{}{}
+	<-t.resume
+	if s.isAborted {
+		runtime.Goexit()
+	}
+}
+
+type taskKey struct{}
+
+func mustGetTask(ctx context.Context) *task {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		panic("simulation: context of no task")
+	}
+	return task
+}
+
+type timer struct {
+	deadline  time.Time
+	id        int
+	fire      func()
+	isStopped bool
+}
+
+// Yield is a scheduling point for the task of the given context.
+func Yield(ctx context.Context) {
+	mustGetTask(ctx).park(taskRunnable)
+}
+
+// Now returns the virtual time of the simulation of the task of the given context.
+func Now(ctx context.Context) time.Time {
+	return mustGetTask(ctx).simulation.now
+}
+
+// Sleep blocks the task of the given context for the given duration of virtual time.
+func Sleep(ctx context.Context, d time.Duration) {
+	task := mustGetTask(ctx)
+	if d <= 0 {
+		task.park(taskRunnable)
+		return
+	}
+	s := task.simulation
+	s.addTimer(s.now.Add(d), func() { task.state = taskRunnable })
+	task.park(taskSleeping)
+}
+
+// Rand returns the random number generator of the task of the given context, which is
+// seeded by the simulation.
+func Rand(ctx context.Context) *rand.Rand {
+	return mustGetTask(ctx).rand
+}
+
+// WithTimeout is like context.WithTimeout, but the timeout is in virtual time of the
+// simulation of the task of the given context. The context returned is canceled by the
+// simulation once the timeout is reached, and its Err then returns
+// context.DeadlineExceeded. It does not report the deadline, which has no meaning in
+// real time.
+func WithTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	s := mustGetTask(ctx).simulation
+	ctx, cancel := context.WithCancel(ctx)
+	timeoutCtx := &timeoutContext{Context: ctx}
+	timer := s.addTimer(s.now.Add(d), func() {
+		atomic.StoreInt32(&timeoutCtx.isExpired, 1)
+		cancel()
+	})
+	return timeoutCtx, func() {
+		timer.isStopped = true
+		cancel()
+	}
+}
+
+type timeoutContext struct {
+	context.Context
+
+	isExpired int32
+}
+
+func (tc *timeoutContext) Err() error {
+	err := tc.Context.Err()
+	if err != nil && atomic.LoadInt32(&tc.isExpired) != 0 {
+		return context.DeadlineExceeded
+	}
+	return err
+}
+
+func (s *Simulation) record(task *task, operation *versionedkv.Operation) {
+	operation.ReturnTime = s.tick()
+	s.operations = append(s.operations, *operation)
+	s.trace = append(s.trace, fmt.Sprintf("%v %s: %v", s.now.Sub(s.options.StartTime), task.name, operation))
+}
+
+// ErrDeadlock is returned when the tasks of a simulation are blocked with no timers to
+// fire.
+var ErrDeadlock error = errors.New("simulation: deadlock")
+
+// ErrTooManySteps is returned when a simulation reaches the maximum number of steps.
+var ErrTooManySteps error = errors.New("simulation: too many steps")
+
+// ErrWaiterNotReturning is returned when WaitForValue on the storage of a simulation does
+// not return, in real time, once the value has been written, the context given has been
+// canceled or the storage has been closed.
+var ErrWaiterNotReturning error = errors.New("simulation: waiter not returning")
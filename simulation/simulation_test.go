@@ -0,0 +1,113 @@
+package simulation_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/simulation"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulation(t *testing.T) {
+	DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return memorystorage.New(), nil
+	}, 20)
+}
+
+func TestSimulation_Replay(t *testing.T) {
+	t.Parallel()
+	run := func(seed int64) []string {
+		s := memorystorage.New()
+		defer s.Close()
+		simulation := New(s, Options{Seed: seed})
+		for i := 0; i < 3; i++ {
+			workerID := strconv.Itoa(i + 1)
+			simulation.Go("worker"+workerID, func(ctx context.Context) {
+				for j := 0; j < 10; j++ {
+					_, version, _ := simulation.Storage().GetValue(ctx, "foo")
+					simulation.Storage().CreateOrUpdateValue(ctx, "foo", workerID, version)
+					Sleep(ctx, time.Duration(Rand(ctx).Intn(10))*time.Millisecond)
+				}
+			})
+		}
+		err := simulation.Run()
+		assert.NoError(t, err)
+		return simulation.Trace()
+	}
+	trace := run(1)
+	assert.Len(t, trace, 60)
+	assert.Equal(t, trace, run(1))
+	assert.NotEqual(t, trace, run(2))
+}
+
+func TestSimulation_Timeouts(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	simulation := New(s, Options{})
+	startTime := simulation.Now()
+	var err error
+	simulation.Go("waiter", func(ctx context.Context) {
+		ctx, cancel := WithTimeout(ctx, time.Hour)
+		defer cancel()
+		_, _, err = simulation.Storage().WaitForValue(ctx, "foo", nil)
+	})
+	realStartTime := time.Now()
+	assert.NoError(t, simulation.Run())
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Equal(t, time.Hour, simulation.Now().Sub(startTime))
+	assert.Less(t, int64(time.Since(realStartTime)), int64(time.Second))
+}
+
+func TestSimulation_Expiry(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	simulation := New(s, Options{Seed: 1})
+	const ttl = 10 * time.Second
+	// The holder renews a lease, which holds its expiry time, three times then crashes.
+	simulation.Go("holder", func(ctx context.Context) {
+		var version versionedkv.Version
+		for i := 0; i < 3; i++ {
+			expiry := Now(ctx).Add(ttl).UnixNano()
+			version, _ = simulation.Storage().CreateOrUpdateValue(ctx, "lease", strconv.FormatInt(expiry, 10), version)
+			Sleep(ctx, ttl/2)
+		}
+	})
+	var deletionTime time.Time
+	simulation.Go("reaper", func(ctx context.Context) {
+		for {
+			value, version, _ := simulation.Storage().GetValue(ctx, "lease")
+			if version != nil {
+				expiry, _ := strconv.ParseInt(value, 10, 64)
+				if Now(ctx).UnixNano() >= expiry {
+					simulation.Storage().DeleteValue(ctx, "lease", version)
+					deletionTime = Now(ctx)
+					return
+				}
+			}
+			Sleep(ctx, time.Second)
+		}
+	})
+	startTime := simulation.Now()
+	assert.NoError(t, simulation.Run())
+	// The last renewal is at 10s and lasts until 20s.
+	assert.Equal(t, 20*time.Second, deletionTime.Sub(startTime))
+}
+
+func TestSimulation_Deadlock(t *testing.T) {
+	t.Parallel()
+	s := memorystorage.New()
+	defer s.Close()
+	simulation := New(s, Options{})
+	simulation.Go("waiter", func(ctx context.Context) {
+		simulation.Storage().WaitForValue(ctx, "foo", nil)
+	})
+	err := simulation.Run()
+	assert.True(t, errors.Is(err, ErrDeadlock), err)
+}
@@ -0,0 +1,144 @@
+package simulation
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/go-tk/versionedkv"
+)
+
+type simulatedStorage struct {
+	simulation *Simulation
+	storage    versionedkv.Storage
+}
+
+func (ss *simulatedStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		return ss.storage.GetValue(ctx, key)
+	}
+	operation := task.beginOperation(versionedkv.OperationGetValue, key)
+	operation.Value, operation.NewVersion, operation.Err = ss.storage.GetValue(ctx, key)
+	ss.simulation.record(task, &operation)
+	return operation.Value, operation.NewVersion, operation.Err
+}
+
+func (ss *simulatedStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		return ss.storage.WaitForValue(ctx, key, oldVersion)
+	}
+	operation := task.beginOperation(versionedkv.OperationWaitForValue, key)
+	operation.OldVersion = oldVersion
+	// Since only one task runs at a time, the value cannot change between GetValue and
+	// WaitForValue, which tells whether WaitForValue is going to block.
+	_, version, err := ss.storage.GetValue(ctx, key)
+	isBlocking := err == nil && ctx.Err() == nil &&
+		((version == nil && oldVersion == nil) || (version != nil && reflect.DeepEqual(version, oldVersion)))
+	wait := wait{
+		ctx:     ctx,
+		key:     key,
+		results: make(chan waitResult, 1),
+	}
+	go func() {
+		value, newVersion, err := ss.storage.WaitForValue(ctx, key, oldVersion)
+		wait.results <- waitResult{value, newVersion, err}
+	}()
+	task.wait = &wait
+	if isBlocking {
+		task.park(taskWaiting)
+	} else {
+		ss.simulation.collectWait(task)
+		if task.state != taskRunnable {
+			task.park(taskWaiting)
+		}
+	}
+	task.wait = nil
+	operation.Value, operation.NewVersion, operation.Err = wait.result.Value, wait.result.NewVersion, wait.result.Err
+	ss.simulation.record(task, &operation)
+	return operation.Value, operation.NewVersion, operation.Err
+}
+
+func (ss *simulatedStorage) CreateValue(ctx context.Context, key, value string) (versionedkv.Version, error) {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		return ss.storage.CreateValue(ctx, key, value)
+	}
+	operation := task.beginOperation(versionedkv.OperationCreateValue, key)
+	operation.Value = value
+	operation.NewVersion, operation.Err = ss.storage.CreateValue(ctx, key, value)
+	ss.endWrite(task, &operation)
+	return operation.NewVersion, operation.Err
+}
+
+func (ss *simulatedStorage) UpdateValue(ctx context.Context, key, value string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		return ss.storage.UpdateValue(ctx, key, value, oldVersion)
+	}
+	operation := task.beginOperation(versionedkv.OperationUpdateValue, key)
+	operation.Value = value
+	operation.OldVersion = oldVersion
+	operation.NewVersion, operation.Err = ss.storage.UpdateValue(ctx, key, value, oldVersion)
+	ss.endWrite(task, &operation)
+	return operation.NewVersion, operation.Err
+}
+
+func (ss *simulatedStorage) CreateOrUpdateValue(ctx context.Context, key, value string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		return ss.storage.CreateOrUpdateValue(ctx, key, value, oldVersion)
+	}
+	operation := task.beginOperation(versionedkv.OperationCreateOrUpdateValue, key)
+	operation.Value = value
+	operation.OldVersion = oldVersion
+	operation.NewVersion, operation.Err = ss.storage.CreateOrUpdateValue(ctx, key, value, oldVersion)
+	ss.endWrite(task, &operation)
+	return operation.NewVersion, operation.Err
+}
+
+func (ss *simulatedStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	task, ok := ctx.Value(taskKey{}).(*task)
+	if !ok {
+		return ss.storage.DeleteValue(ctx, key, version)
+	}
+	operation := task.beginOperation(versionedkv.OperationDeleteValue, key)
+	operation.OldVersion = version
+	operation.OK, operation.Err = ss.storage.DeleteValue(ctx, key, version)
+	ss.endWrite(task, &operation)
+	return operation.OK, operation.Err
+}
+
+func (ss *simulatedStorage) Close() error {
+	err := ss.storage.Close()
+	ss.simulation.wakeAllWaiters()
+	return err
+}
+
+func (ss *simulatedStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	if task, ok := ctx.Value(taskKey{}).(*task); ok {
+		task.park(taskRunnable)
+	}
+	return ss.storage.Inspect(ctx)
+}
+
+func (ss *simulatedStorage) endWrite(task *task, operation *versionedkv.Operation) {
+	ss.simulation.record(task, operation)
+	if operation.NewVersion != nil || operation.OK {
+		// Every write which succeeds changes the version of the value, which wakes all
+		// the waiters of the value.
+		ss.simulation.wakeWaiters(operation.Key)
+	}
+}
+
+func (t *task) beginOperation(name string, key string) versionedkv.Operation {
+	t.park(taskRunnable)
+	return versionedkv.Operation{
+		Name:     name,
+		Key:      key,
+		CallTime: t.simulation.tick(),
+	}
+}
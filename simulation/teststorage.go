@@ -0,0 +1,65 @@
+package simulation
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/stretchr/testify/assert"
+)
+
+// DoTestStorage tests storages created by the given storage factory with simulations of
+// randomized concurrent workloads, seeded from 1 to the given number of seeds, checking
+// the operations recorded with versionedkv.CheckLinearizability.
+func DoTestStorage(t *testing.T, sf versionedkv.StorageFactory, numberOfSeeds int) {
+	for seed := int64(1); seed <= int64(numberOfSeeds); seed++ {
+		seed := seed
+		t.Run(fmt.Sprintf("Seed=%d", seed), func(t *testing.T) {
+			t.Parallel()
+			DoTestStorageWithSeed(t, sf, seed)
+		})
+	}
+}
+
+// DoTestStorageWithSeed is like DoTestStorage, but runs one simulation with the given
+// seed, e.g. to replay a failure. On failure, the trace of the simulation is logged.
+func DoTestStorageWithSeed(t *testing.T, sf versionedkv.StorageFactory, seed int64) {
+	const (
+		numberOfKeys                = 2
+		numberOfWorkersPerKey       = 4
+		numberOfOperationsPerWorker = 50
+	)
+	s, err := sf()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	simulation := New(s, Options{Seed: seed})
+	for i := 0; i < numberOfKeys; i++ {
+		key := fmt.Sprintf("key%d", i+1)
+		for j := 0; j < numberOfWorkersPerKey; j++ {
+			workerID := j + 1
+			simulation.Go(fmt.Sprintf("%s/worker%d", key, workerID), func(ctx context.Context) {
+				workload := versionedkv.RandomWorkload{
+					Key:                key,
+					WorkerID:           workerID,
+					NumberOfOperations: numberOfOperationsPerWorker,
+					Rand:               Rand(ctx),
+					MaxWaitTimeout:     time.Second,
+					WithTimeout:        WithTimeout,
+					Sleep:              Sleep,
+				}
+				workload.Run(ctx, t, simulation.Storage())
+			})
+		}
+	}
+	err = simulation.Run()
+	ok := assert.NoError(t, err)
+	ok = assert.NoError(t, versionedkv.CheckLinearizability(simulation.Operations())) && ok
+	if !ok {
+		t.Logf("seed: %d\ntrace:\n%s", seed, strings.Join(simulation.Trace(), "\n"))
+	}
+}
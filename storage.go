@@ -66,6 +66,19 @@ type Storage interface {
 	Inspect(ctx context.Context) (details StorageDetails, err error)
 }
 
+// Operation names, i.e. the names of the methods of Storage, for histories (see
+// Operation) and for decorators configured per operation.
+const (
+	OperationGetValue            = "GetValue"
+	OperationWaitForValue        = "WaitForValue"
+	OperationCreateValue         = "CreateValue"
+	OperationUpdateValue         = "UpdateValue"
+	OperationCreateOrUpdateValue = "CreateOrUpdateValue"
+	OperationDeleteValue         = "DeleteValue"
+	OperationClose               = "Close"
+	OperationInspect             = "Inspect"
+)
+
 // Version represents a specific version of a value in a storage.
 type Version interface{}
 
@@ -73,6 +86,10 @@ type Version interface{}
 type StorageDetails struct {
 	Values   map[string]ValueDetails
 	IsClosed bool
+
+	// NumberOfWatchers is the number of WaitForValue calls blocking, for storages keeping
+	// track of it, otherwise 0.
+	NumberOfWatchers int
 }
 
 // ValueDetails represents the detailed information of a value in a storage.
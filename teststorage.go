@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"math/rand"
+	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -16,8 +19,12 @@ import (
 // StorageFactory is the type of the function creating storages.
 type StorageFactory func() (storage Storage, err error)
 
-// DoTestStorage tests storages created by the given storage factory.
+// DoTestStorage tests storages created by the given storage factory. The test groups of
+// capabilities (see Capability) not advertised by the storages are skipped.
 func DoTestStorage(t *testing.T, sf StorageFactory) {
+	t.Run("Leaks", func(t *testing.T) {
+		DoTestStorageLeaks(t, sf)
+	})
 	t.Run("GetValue", func(t *testing.T) {
 		t.Parallel()
 		DoTestStorageGetValue(t, sf)
@@ -50,6 +57,22 @@ func DoTestStorage(t *testing.T, sf StorageFactory) {
 		t.Parallel()
 		DoTestStorageRaceCondition(t, sf)
 	})
+	t.Run("Linearizability", func(t *testing.T) {
+		t.Parallel()
+		DoTestStorageLinearizability(t, sf)
+	})
+	t.Run("ReadOnly", func(t *testing.T) {
+		t.Parallel()
+		DoTestReadOnlyStorage(t, sf)
+	})
+	t.Run("BinaryValues", func(t *testing.T) {
+		t.Parallel()
+		DoTestStorageBinaryValues(t, sf)
+	})
+	t.Run("WatcherCounting", func(t *testing.T) {
+		t.Parallel()
+		DoTestStorageWatcherCounting(t, sf)
+	})
 }
 
 // DoTestStorageGetValue tests storages created by the given storage factory.
@@ -992,12 +1015,89 @@ func DoTestStorageClose(t *testing.T, sf StorageFactory) {
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
+	rootError := func(err error) error {
+		for err2 := errors.Unwrap(err); err2 != nil; err, err2 = err2, errors.Unwrap(err2) {
+		}
+		return err
+	}
+	ctx := context.Background()
+	version, err := s.CreateValue(ctx, "foo", "123")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	// Blocked waiters must be woken by Close.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		for _, key := range []string{"foo", "bar"} {
+			key := key
+			var oldVersion Version
+			if key == "foo" {
+				oldVersion = version
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := s.WaitForValue(ctx, key, oldVersion)
+				assert.Equal(t, ErrStorageClosed, rootError(err))
+			}()
+		}
+	}
+	// Writes racing with Close may succeed, but once Close has returned, all of them
+	// must fail.
+	var isClosed int32
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("key%d", i+1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				wasClosed := atomic.LoadInt32(&isClosed) != 0
+				_, err := s.CreateOrUpdateValue(ctx, key, strconv.Itoa(j), nil)
+				if err != nil {
+					assert.Equal(t, ErrStorageClosed, rootError(err))
+					return
+				}
+				if !assert.False(t, wasClosed, "write succeeded after Close") {
+					return
+				}
+			}
+		}()
+	}
+	time.Sleep(100 * time.Millisecond)
 	err = s.Close()
 	assert.NoError(t, err)
-	err = s.Close()
-	for err2 := errors.Unwrap(err); err2 != nil; err, err2 = err2, errors.Unwrap(err2) {
+	atomic.StoreInt32(&isClosed, 1)
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("waiters or writers not returning after Close")
+	}
+
+	// Every operation must fail after Close.
+	for _, f := range []func() error{
+		func() error { _, _, err := s.GetValue(ctx, "foo"); return err },
+		func() error { _, _, err := s.WaitForValue(ctx, "foo", nil); return err },
+		func() error { _, _, err := s.WaitForValue(ctx, "foo", version); return err },
+		func() error { _, err := s.CreateValue(ctx, "baz", "abc"); return err },
+		func() error { _, err := s.UpdateValue(ctx, "foo", "abc", nil); return err },
+		func() error { _, err := s.UpdateValue(ctx, "foo", "abc", version); return err },
+		func() error { _, err := s.CreateOrUpdateValue(ctx, "baz", "abc", nil); return err },
+		func() error { _, err := s.CreateOrUpdateValue(ctx, "foo", "abc", version); return err },
+		func() error { _, err := s.DeleteValue(ctx, "foo", nil); return err },
+		func() error { _, err := s.DeleteValue(ctx, "foo", version); return err },
+		s.Close,
+	} {
+		assert.Equal(t, ErrStorageClosed, rootError(f()))
 	}
-	assert.Equal(t, ErrStorageClosed, err)
+	state, err := s.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.True(t, state.IsClosed)
 }
 
 // DoTestStorageRaceCondition tests storages created by the given storage factory.
@@ -1201,3 +1301,404 @@ func DoTestStorageRaceCondition(t *testing.T, sf StorageFactory) {
 	}
 	wg.Wait()
 }
+
+// DoTestStorageLeaks tests storages created by the given storage factory for leaks after
+// WaitForValue calls have been canceled or completed: there must be neither values nor
+// watchers left other than those expected, as reported by Inspect, and after Close there
+// must be no goroutines left. Since the number of goroutines of the whole process is
+// checked, it must not run in parallel with other tests. It skips if the storages do not
+// advertise CapabilityLeakChecking.
+func DoTestStorageLeaks(t *testing.T, sf StorageFactory) {
+	numberOfGoroutines := runtime.NumGoroutine()
+	s := newStorageWithCapability(t, sf, CapabilityLeakChecking)
+	ctx := context.Background()
+	versions := make(map[string]Version)
+	for _, key := range []string{"foo", "qux", "quux"} {
+		version, err := s.CreateValue(ctx, key, "123")
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		versions[key] = version
+	}
+
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		// To be canceled.
+		for _, key := range []string{"foo", "bar"} {
+			key, version := key, versions[key]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := s.WaitForValue(ctx2, key, version)
+				assert.True(t, errors.Is(err, context.Canceled), err)
+			}()
+		}
+		// To be completed.
+		for _, key := range []string{"baz", "qux", "quux"} {
+			key, version := key, versions[key]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, _, err := s.WaitForValue(ctx, key, version)
+				assert.NoError(t, err)
+			}()
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	version, err := s.CreateValue(ctx, "baz", "abc")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	versions["baz"] = version
+	version, err = s.UpdateValue(ctx, "qux", "abc", versions["qux"])
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	versions["qux"] = version
+	_, err = s.DeleteValue(ctx, "quux", versions["quux"])
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	wg.Wait()
+
+	state, err := s.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, StorageDetails{
+		Values: map[string]ValueDetails{
+			"foo": {V: "123", Version: versions["foo"]},
+			"baz": {V: "abc", Version: versions["baz"]},
+			"qux": {V: "abc", Version: versions["qux"]},
+		},
+	}, state)
+
+	err = s.Close()
+	assert.NoError(t, err)
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > numberOfGoroutines && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > numberOfGoroutines {
+		buffer := make([]byte, 1<<20)
+		buffer = buffer[:runtime.Stack(buffer, true)]
+		t.Errorf("%d goroutine(s) leaked:\n%s", n-numberOfGoroutines, buffer)
+	}
+}
+
+// DoTestStorageLinearizability tests storages created by the given storage factory, by
+// checking the history of a randomized concurrent workload with CheckLinearizability, or
+// skips if the storages do not advertise CapabilityLinearizability.
+func DoTestStorageLinearizability(t *testing.T, sf StorageFactory) {
+	const (
+		numberOfKeys                = 3
+		numberOfWorkersPerKey       = 4
+		numberOfOperationsPerWorker = 50
+	)
+	s := newStorageWithCapability(t, sf, CapabilityLinearizability)
+	defer s.Close()
+	var history History
+	rs := RecordHistory(s, &history)
+	var wg sync.WaitGroup
+	for i := 0; i < numberOfKeys; i++ {
+		key := fmt.Sprintf("key%d", i+1)
+		for j := 0; j < numberOfWorkersPerKey; j++ {
+			workerID := j + 1
+			wg.Add(1)
+			workload := RandomWorkload{
+				Key:                key,
+				WorkerID:           workerID,
+				NumberOfOperations: numberOfOperationsPerWorker,
+				Rand:               rand.New(rand.NewSource(rand.Int63())),
+				MaxWaitTimeout:     20 * time.Millisecond,
+				WithTimeout:        context.WithTimeout,
+			}
+			go func() {
+				defer wg.Done()
+				workload.Run(context.Background(), t, rs)
+			}()
+		}
+	}
+	wg.Wait()
+	assert.NoError(t, CheckLinearizability(history.Operations()))
+}
+
+// RandomWorkload represents a randomized workload of a worker on a key, which writes values
+// tagged with the worker ID and gives versions observed as old-versions, to exercise both
+// successful and failed compare-and-swaps. It is shared by DoTestStorageLinearizability and
+// by package simulation, which runs it on a virtual clock.
+type RandomWorkload struct {
+	Key                string
+	WorkerID           int
+	NumberOfOperations int
+
+	// Rand is the source of random choices.
+	Rand *rand.Rand
+
+	// MaxWaitTimeout is the maximum timeout of WaitForValue.
+	MaxWaitTimeout time.Duration
+
+	// WithTimeout derives contexts with timeouts for WaitForValue, e.g. context.WithTimeout.
+	WithTimeout func(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc)
+
+	// Sleep, if not nil, makes the workload sleep between operations from time to time.
+	Sleep func(ctx context.Context, duration time.Duration)
+}
+
+// Run runs the workload on the given storage, failing the test on unexpected errors.
+// WaitForValue timing out is expected.
+func (rw *RandomWorkload) Run(ctx context.Context, t *testing.T, storage Storage) {
+	// Versions observed, the latest last.
+	var versions []Version
+	observeVersion := func(version Version) {
+		if version == nil {
+			return
+		}
+		versions = append(versions, version)
+		if len(versions) > 3 {
+			versions = versions[1:]
+		}
+	}
+	pickVersion := func() Version {
+		if len(versions) == 0 || rw.Rand.Intn(4) == 0 {
+			return nil
+		}
+		return versions[len(versions)-1-rw.Rand.Intn(len(versions))]
+	}
+	numberOfChoices := 6
+	if rw.Sleep != nil {
+		numberOfChoices++
+	}
+	for i := 0; i < rw.NumberOfOperations; i++ {
+		value := fmt.Sprintf("%d-%d", rw.WorkerID, i)
+		switch rw.Rand.Intn(numberOfChoices) {
+		case 0:
+			_, version, err := storage.GetValue(ctx, rw.Key)
+			if !assert.NoError(t, err) {
+				return
+			}
+			observeVersion(version)
+		case 1:
+			timeout := time.Duration(1+rw.Rand.Intn(int(rw.MaxWaitTimeout/time.Millisecond))) * time.Millisecond
+			ctx, cancel := rw.WithTimeout(ctx, timeout)
+			_, newVersion, err := storage.WaitForValue(ctx, rw.Key, pickVersion())
+			cancel()
+			if errors.Is(err, context.DeadlineExceeded) {
+				continue
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			observeVersion(newVersion)
+		case 2:
+			version, err := storage.CreateValue(ctx, rw.Key, value)
+			if !assert.NoError(t, err) {
+				return
+			}
+			observeVersion(version)
+		case 3:
+			newVersion, err := storage.UpdateValue(ctx, rw.Key, value, pickVersion())
+			if !assert.NoError(t, err) {
+				return
+			}
+			observeVersion(newVersion)
+		case 4:
+			newVersion, err := storage.CreateOrUpdateValue(ctx, rw.Key, value, pickVersion())
+			if !assert.NoError(t, err) {
+				return
+			}
+			observeVersion(newVersion)
+		case 5:
+			_, err := storage.DeleteValue(ctx, rw.Key, pickVersion())
+			if !assert.NoError(t, err) {
+				return
+			}
+		case 6:
+			rw.Sleep(ctx, time.Duration(rw.Rand.Intn(100))*time.Millisecond)
+		}
+	}
+}
+
+// DoTestReadOnlyStorage tests read-only views of storages created by the given storage factory.
+func DoTestReadOnlyStorage(t *testing.T, sf StorageFactory) {
+	s, err := sf()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	ctx := context.Background()
+	version, err := s.CreateValue(ctx, "foo", "123")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	expectedState, err := s.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ros := ReadOnly(s)
+
+	value, version2, err := ros.GetValue(ctx, "foo")
+	assert.NoError(t, err)
+	assert.Equal(t, "123", value)
+	assert.Equal(t, version, version2)
+	value, version2, err = ros.WaitForValue(ctx, "foo", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "123", value)
+	assert.Equal(t, version, version2)
+	state, err := ros.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedState, state)
+
+	for _, f := range []func() error{
+		func() error { _, err := ros.CreateValue(ctx, "bar", "abc"); return err },
+		func() error { _, err := ros.UpdateValue(ctx, "foo", "abc", nil); return err },
+		func() error { _, err := ros.UpdateValue(ctx, "foo", "abc", version); return err },
+		func() error { _, err := ros.CreateOrUpdateValue(ctx, "bar", "abc", nil); return err },
+		func() error { _, err := ros.CreateOrUpdateValue(ctx, "foo", "abc", version); return err },
+		func() error { _, err := ros.DeleteValue(ctx, "foo", nil); return err },
+		func() error { _, err := ros.DeleteValue(ctx, "foo", version); return err },
+		ros.Close,
+	} {
+		err := f()
+		for err2 := errors.Unwrap(err); err2 != nil; err, err2 = err2, errors.Unwrap(err2) {
+		}
+		assert.Equal(t, ErrReadOnly, err)
+	}
+	state, err = s.Inspect(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, expectedState, state)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		value, newVersion, err := ros.WaitForValue(ctx, "foo", version)
+		assert.NoError(t, err)
+		assert.Equal(t, "abc", value)
+		assert.NotNil(t, newVersion)
+		assert.NotEqual(t, version, newVersion)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_, err = s.UpdateValue(ctx, "foo", "abc", version)
+	assert.NoError(t, err)
+	wg.Wait()
+}
+
+// DoTestStorageBinaryValues tests storages created by the given storage factory for
+// CapabilityBinaryValues, or skips if the storages do not advertise it.
+func DoTestStorageBinaryValues(t *testing.T, sf StorageFactory) {
+	s := newStorageWithCapability(t, sf, CapabilityBinaryValues)
+	defer s.Close()
+	ctx := context.Background()
+	allBytes := make([]byte, 256)
+	for i := range allBytes {
+		allBytes[i] = byte(i)
+	}
+	values := []string{"\x00", "\x00abc\x00", "\xff\xfe", "\xc3\x28", string(allBytes)}
+	versions := make(map[string]Version)
+	for i, value := range values {
+		key := fmt.Sprintf("key%d", i+1)
+		version, err := s.CreateValue(ctx, key, value)
+		if !assert.NoError(t, err) || !assert.NotNil(t, version) {
+			t.FailNow()
+		}
+		versions[key] = version
+	}
+	for i, value := range values {
+		key := fmt.Sprintf("key%d", i+1)
+		value2, version, err := s.GetValue(ctx, key)
+		if assert.NoError(t, err) {
+			assert.Equal(t, value, value2)
+			assert.Equal(t, versions[key], version)
+		}
+	}
+	version, err := s.UpdateValue(ctx, "key1", string(allBytes), versions["key1"])
+	if !assert.NoError(t, err) || !assert.NotNil(t, version) {
+		t.FailNow()
+	}
+	versions["key1"] = version
+	details, err := s.Inspect(ctx)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for i, value := range values {
+		key := fmt.Sprintf("key%d", i+1)
+		if i == 0 {
+			value = string(allBytes)
+		}
+		assert.Equal(t, ValueDetails{V: value, Version: versions[key]}, details.Values[key])
+	}
+}
+
+// DoTestStorageWatcherCounting tests storages created by the given storage factory for
+// CapabilityWatcherCounting, or skips if the storages do not advertise it.
+func DoTestStorageWatcherCounting(t *testing.T, sf StorageFactory) {
+	s := newStorageWithCapability(t, sf, CapabilityWatcherCounting)
+	defer s.Close()
+	ctx := context.Background()
+	version, err := s.CreateValue(ctx, "foo", "123")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	waitForNumberOfWatchers := func(numberOfWatchers int) {
+		var details StorageDetails
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			var err error
+			details, err = s.Inspect(ctx)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			if details.NumberOfWatchers == numberOfWatchers || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		assert.Equal(t, numberOfWatchers, details.NumberOfWatchers)
+	}
+	waitForNumberOfWatchers(0)
+
+	ctx2, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		// To be completed.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.WaitForValue(ctx, "foo", version)
+			assert.NoError(t, err)
+		}()
+		// To be canceled.
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _, err := s.WaitForValue(ctx2, "bar", nil)
+			assert.True(t, errors.Is(err, context.Canceled), err)
+		}()
+	}
+	waitForNumberOfWatchers(20)
+	_, err = s.UpdateValue(ctx, "foo", "abc", version)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	waitForNumberOfWatchers(10)
+	cancel()
+	wg.Wait()
+	waitForNumberOfWatchers(0)
+}
+
+func newStorageWithCapability(t *testing.T, sf StorageFactory, capability Capability) Storage {
+	s, err := sf()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !HasCapability(s, capability) {
+		s.Close()
+		t.Skipf("capability %q not advertised", capability)
+	}
+	return s
+}
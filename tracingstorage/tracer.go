@@ -0,0 +1,130 @@
+package tracingstorage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Tracer creates spans. It mirrors the shape of OpenTelemetry tracers, so it is easy to
+// adapt an OpenTelemetry tracer to it.
+type Tracer interface {
+	// StartSpan starts a span with the given name as a child of the span in the given context,
+	// if any, and returns a context carrying the new span.
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span represents an operation being traced.
+type Span interface {
+	// SetAttributes sets the given attributes to the span.
+	SetAttributes(attributes ...Attribute)
+
+	// RecordError records the given error as an error of the span.
+	RecordError(err error)
+
+	// End ends the span.
+	End()
+}
+
+// Attribute represents a key/value pair describing a span.
+type Attribute struct {
+	Key   string
+	Value string
+}
+
+// Recorder is a tracer recording spans in memory, for testing purposes.
+type Recorder struct {
+	mu     sync.Mutex
+	spans  []RecordedSpan
+	lastID int
+}
+
+var _ Tracer = (*Recorder)(nil)
+
+// NewRecorder creates a new recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// RecordedSpan represents a span recorded.
+type RecordedSpan struct {
+	ID         int
+	ParentID   int
+	Name       string
+	Attributes map[string]string
+	Err        error
+	StartTime  time.Time
+	EndTime    time.Time
+}
+
+// Duration returns the duration of the span.
+func (rs *RecordedSpan) Duration() time.Duration {
+	return rs.EndTime.Sub(rs.StartTime)
+}
+
+// StartSpan implements Tracer.StartSpan.
+func (r *Recorder) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	r.mu.Lock()
+	r.lastID++
+	id := r.lastID
+	r.mu.Unlock()
+	span := &recorderSpan{
+		recorder: r,
+		recordedSpan: RecordedSpan{
+			ID:         id,
+			Name:       name,
+			Attributes: make(map[string]string),
+			StartTime:  time.Now(),
+		},
+	}
+	if parentSpan, ok := ctx.Value(recorderSpanKey{}).(*recorderSpan); ok && parentSpan.recorder == r {
+		span.recordedSpan.ParentID = parentSpan.recordedSpan.ID
+	}
+	return context.WithValue(ctx, recorderSpanKey{}, span), span
+}
+
+// Spans returns the spans ended so far, in the order of their ends.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedSpan(nil), r.spans...)
+}
+
+// Reset discards the spans ended so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.spans = nil
+	r.mu.Unlock()
+}
+
+type recorderSpan struct {
+	recorder     *Recorder
+	mu           sync.Mutex
+	recordedSpan RecordedSpan
+}
+
+type recorderSpanKey struct{}
+
+func (rs *recorderSpan) SetAttributes(attributes ...Attribute) {
+	rs.mu.Lock()
+	for _, attribute := range attributes {
+		rs.recordedSpan.Attributes[attribute.Key] = attribute.Value
+	}
+	rs.mu.Unlock()
+}
+
+func (rs *recorderSpan) RecordError(err error) {
+	rs.mu.Lock()
+	rs.recordedSpan.Err = err
+	rs.mu.Unlock()
+}
+
+func (rs *recorderSpan) End() {
+	rs.mu.Lock()
+	rs.recordedSpan.EndTime = time.Now()
+	recordedSpan := rs.recordedSpan
+	rs.mu.Unlock()
+	rs.recorder.mu.Lock()
+	rs.recorder.spans = append(rs.recorder.spans, recordedSpan)
+	rs.recorder.mu.Unlock()
+}
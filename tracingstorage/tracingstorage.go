@@ -0,0 +1,209 @@
+// Package tracingstorage provides a decorator of versionedkv creating a span for each
+// storage operation.
+//
+// Spans are named after operations, e.g. "versionedkv.WaitForValue", so long waits are
+// told apart from slow writes, and carry the following attributes:
+//
+//	versionedkv.operation    the name of the operation
+//	versionedkv.key          the key operated on
+//	versionedkv.outcome      see the Outcome constants
+//	versionedkv.old_version  the old version given, if any
+//	versionedkv.version      the version returned, if any
+//
+// Spans are started as children of the spans in the contexts given, and the contexts
+// carrying the new spans are passed down to the storage decorated.
+package tracingstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-tk/versionedkv"
+)
+
+// Attribute keys.
+const (
+	AttributeOperation  = "versionedkv.operation"
+	AttributeKey        = "versionedkv.key"
+	AttributeOutcome    = "versionedkv.outcome"
+	AttributeOldVersion = "versionedkv.old_version"
+	AttributeVersion    = "versionedkv.version"
+)
+
+// Outcomes of operations, derived only from what the operations returned. UpdateValue
+// and DeleteValue failing with a nil version or false are not-found if no version was
+// given, otherwise conflict-or-not-found, as the storage does not tell the two apart.
+// WaitForValue returning a nil version is not-found, whether the value did not exist
+// or was deleted while waiting.
+const (
+	OutcomeOK                 = "ok"
+	OutcomeFound              = "found"
+	OutcomeNotFound           = "not-found"
+	OutcomeChanged            = "changed"
+	OutcomeCreated            = "created"
+	OutcomeUpdated            = "updated"
+	OutcomeWritten            = "written"
+	OutcomeConflict           = "conflict"
+	OutcomeConflictOrNotFound = "conflict-or-not-found"
+	OutcomeDeleted            = "deleted"
+	OutcomeTimeout            = "timeout"
+	OutcomeCanceled           = "canceled"
+	OutcomeClosed             = "closed"
+	OutcomeError              = "error"
+)
+
+// New creates a new storage tracing operations on the given storage with the given tracer.
+func New(storage versionedkv.Storage, tracer Tracer) versionedkv.Storage {
+	return &tracingStorage{
+		storage: storage,
+		tracer:  tracer,
+	}
+}
+
+type tracingStorage struct {
+	storage versionedkv.Storage
+	tracer  Tracer
+}
+
+func (ts *tracingStorage) GetValue(ctx context.Context, key string) (string, versionedkv.Version, error) {
+	ctx, span := ts.startSpan(ctx, versionedkv.OperationGetValue, key, nil)
+	defer span.End()
+	val, version, err := ts.storage.GetValue(ctx, key)
+	outcome := OutcomeFound
+	if version == nil {
+		outcome = OutcomeNotFound
+	}
+	endSpan(span, outcome, version, err)
+	return val, version, err
+}
+
+func (ts *tracingStorage) WaitForValue(ctx context.Context, key string,
+	oldVersion versionedkv.Version) (string, versionedkv.Version, error) {
+	ctx, span := ts.startSpan(ctx, versionedkv.OperationWaitForValue, key, oldVersion)
+	defer span.End()
+	val, newVersion, err := ts.storage.WaitForValue(ctx, key, oldVersion)
+	outcome := OutcomeChanged
+	if newVersion == nil {
+		outcome = OutcomeNotFound
+	}
+	endSpan(span, outcome, newVersion, err)
+	return val, newVersion, err
+}
+
+func (ts *tracingStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	ctx, span := ts.startSpan(ctx, versionedkv.OperationCreateValue, key, nil)
+	defer span.End()
+	version, err := ts.storage.CreateValue(ctx, key, val)
+	outcome := OutcomeCreated
+	if version == nil {
+		outcome = OutcomeConflict
+	}
+	endSpan(span, outcome, version, err)
+	return version, err
+}
+
+func (ts *tracingStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	ctx, span := ts.startSpan(ctx, versionedkv.OperationUpdateValue, key, oldVersion)
+	defer span.End()
+	newVersion, err := ts.storage.UpdateValue(ctx, key, val, oldVersion)
+	var outcome string
+	switch {
+	case newVersion != nil:
+		outcome = OutcomeUpdated
+	case oldVersion == nil:
+		outcome = OutcomeNotFound
+	default:
+		outcome = OutcomeConflictOrNotFound
+	}
+	endSpan(span, outcome, newVersion, err)
+	return newVersion, err
+}
+
+func (ts *tracingStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	ctx, span := ts.startSpan(ctx, versionedkv.OperationCreateOrUpdateValue, key, oldVersion)
+	defer span.End()
+	newVersion, err := ts.storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+	outcome := OutcomeWritten
+	if newVersion == nil {
+		outcome = OutcomeConflict
+	}
+	endSpan(span, outcome, newVersion, err)
+	return newVersion, err
+}
+
+func (ts *tracingStorage) DeleteValue(ctx context.Context, key string, version versionedkv.Version) (bool, error) {
+	ctx, span := ts.startSpan(ctx, versionedkv.OperationDeleteValue, key, version)
+	defer span.End()
+	ok, err := ts.storage.DeleteValue(ctx, key, version)
+	var outcome string
+	switch {
+	case ok:
+		outcome = OutcomeDeleted
+	case version == nil:
+		outcome = OutcomeNotFound
+	default:
+		outcome = OutcomeConflictOrNotFound
+	}
+	endSpan(span, outcome, nil, err)
+	return ok, err
+}
+
+func (ts *tracingStorage) Close() error {
+	_, span := ts.tracer.StartSpan(context.Background(), "versionedkv."+versionedkv.OperationClose)
+	defer span.End()
+	span.SetAttributes(Attribute{AttributeOperation, versionedkv.OperationClose})
+	err := ts.storage.Close()
+	endSpan(span, OutcomeOK, nil, err)
+	return err
+}
+
+func (ts *tracingStorage) Inspect(ctx context.Context) (versionedkv.StorageDetails, error) {
+	ctx, span := ts.tracer.StartSpan(ctx, "versionedkv."+versionedkv.OperationInspect)
+	defer span.End()
+	span.SetAttributes(Attribute{AttributeOperation, versionedkv.OperationInspect})
+	details, err := ts.storage.Inspect(ctx)
+	endSpan(span, OutcomeOK, nil, err)
+	return details, err
+}
+
+func (ts *tracingStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(ts.storage)
+}
+
+func (ts *tracingStorage) startSpan(ctx context.Context, operation string, key string,
+	oldVersion versionedkv.Version) (context.Context, Span) {
+	ctx, span := ts.tracer.StartSpan(ctx, "versionedkv."+operation)
+	span.SetAttributes(
+		Attribute{AttributeOperation, operation},
+		Attribute{AttributeKey, key},
+	)
+	if oldVersion != nil {
+		span.SetAttributes(Attribute{AttributeOldVersion, fmt.Sprint(oldVersion)})
+	}
+	return ctx, span
+}
+
+func endSpan(span Span, outcome string, version versionedkv.Version, err error) {
+	if err != nil {
+		span.RecordError(err)
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			outcome = OutcomeTimeout
+		case errors.Is(err, context.Canceled):
+			outcome = OutcomeCanceled
+		case errors.Is(err, versionedkv.ErrStorageClosed):
+			outcome = OutcomeClosed
+		default:
+			outcome = OutcomeError
+		}
+		span.SetAttributes(Attribute{AttributeOutcome, outcome})
+		return
+	}
+	span.SetAttributes(Attribute{AttributeOutcome, outcome})
+	if version != nil {
+		span.SetAttributes(Attribute{AttributeVersion, fmt.Sprint(version)})
+	}
+}
@@ -0,0 +1,150 @@
+package tracingstorage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/tracingstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracingStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), NewRecorder()), nil
+	})
+}
+
+func TestTracingStorage_Spans(t *testing.T) {
+	t.Parallel()
+	r := NewRecorder()
+	s := New(memorystorage.New(), r)
+	defer s.Close()
+
+	ctx, parentSpan := r.StartSpan(context.Background(), "parent")
+	version, err := s.CreateValue(ctx, "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.CreateValue(ctx, "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = s.GetValue(ctx, "baz")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	ctx2, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, _, err = s.WaitForValue(ctx2, "foo", version)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, err = s.DeleteValue(ctx, "foo", version)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	parentSpan.End()
+
+	spans := r.Spans()
+	if !assert.Len(t, spans, 6) {
+		t.FailNow()
+	}
+	parentID := spans[5].ID
+	assert.Equal(t, "parent", spans[5].Name)
+	for i, expected := range []struct {
+		Name       string
+		Attributes map[string]string
+	}{
+		{"versionedkv.CreateValue", map[string]string{
+			AttributeOperation: "CreateValue",
+			AttributeKey:       "foo",
+			AttributeOutcome:   OutcomeCreated,
+			AttributeVersion:   fmt.Sprint(version),
+		}},
+		{"versionedkv.CreateValue", map[string]string{
+			AttributeOperation: "CreateValue",
+			AttributeKey:       "foo",
+			AttributeOutcome:   OutcomeConflict,
+		}},
+		{"versionedkv.GetValue", map[string]string{
+			AttributeOperation: "GetValue",
+			AttributeKey:       "baz",
+			AttributeOutcome:   OutcomeNotFound,
+		}},
+		{"versionedkv.WaitForValue", map[string]string{
+			AttributeOperation:  "WaitForValue",
+			AttributeKey:        "foo",
+			AttributeOldVersion: fmt.Sprint(version),
+			AttributeOutcome:    OutcomeTimeout,
+		}},
+		{"versionedkv.DeleteValue", map[string]string{
+			AttributeOperation:  "DeleteValue",
+			AttributeKey:        "foo",
+			AttributeOldVersion: fmt.Sprint(version),
+			AttributeOutcome:    OutcomeDeleted,
+		}},
+	} {
+		span := spans[i]
+		assert.Equal(t, expected.Name, span.Name)
+		assert.Equal(t, expected.Attributes, span.Attributes)
+		assert.Equal(t, parentID, span.ParentID)
+	}
+	assert.Equal(t, context.DeadlineExceeded, spans[3].Err)
+	assert.True(t, spans[3].Duration() >= 100*time.Millisecond)
+}
+
+func TestTracingStorage_NotFound(t *testing.T) {
+	t.Parallel()
+	r := NewRecorder()
+	s := New(memorystorage.New(), r)
+	defer s.Close()
+
+	ctx := context.Background()
+	version, err := s.CreateValue(ctx, "foo", "bar")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.DeleteValue(ctx, "foo", version)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = s.CreateValue(ctx, "bar", "baz")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, f := range []func() error{
+		func() error { _, err := s.UpdateValue(ctx, "foo", "v", nil); return err },
+		func() error { _, err := s.UpdateValue(ctx, "foo", "v", version); return err },
+		func() error { _, err := s.UpdateValue(ctx, "bar", "v", version); return err },
+		func() error { _, err := s.DeleteValue(ctx, "foo", nil); return err },
+		func() error { _, err := s.DeleteValue(ctx, "foo", version); return err },
+		func() error { _, err := s.DeleteValue(ctx, "bar", version); return err },
+		func() error { _, err := s.CreateOrUpdateValue(ctx, "bar", "v", version); return err },
+		func() error { _, _, err := s.WaitForValue(ctx, "foo", version); return err },
+	} {
+		if !assert.NoError(t, f()) {
+			t.FailNow()
+		}
+	}
+
+	spans := r.Spans()
+	if !assert.Len(t, spans, 11) {
+		t.FailNow()
+	}
+	var outcomes []string
+	for _, span := range spans[3:] {
+		outcomes = append(outcomes, span.Attributes[AttributeOutcome])
+	}
+	assert.Equal(t, []string{
+		OutcomeNotFound,
+		OutcomeConflictOrNotFound,
+		OutcomeConflictOrNotFound,
+		OutcomeNotFound,
+		OutcomeConflictOrNotFound,
+		OutcomeConflictOrNotFound,
+		OutcomeConflict,
+		OutcomeNotFound,
+	}, outcomes)
+}
@@ -0,0 +1,327 @@
+package validatingstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema represents a JSON schema.
+//
+// The following subset of JSON Schema is supported: boolean schemas and the keywords type,
+// enum, const, properties, required, additionalProperties, minProperties, maxProperties,
+// items (single schema), minItems, maxItems, minLength, maxLength, pattern, minimum,
+// maximum, exclusiveMinimum and exclusiveMaximum (numbers), as well as the annotations
+// $schema, $id, $comment, title, description, default, examples and deprecated, which have
+// no effect. Other keywords, e.g. allOf, $ref or format, are rejected rather than ignored,
+// so that a schema never enforces less than it says.
+type Schema struct {
+	isFalse bool
+
+	types                []string
+	enum                 []interface{}
+	constValue           *interface{}
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+	items                *Schema
+	minItems             *int
+	maxItems             *int
+	minLength            *int
+	maxLength            *int
+	pattern              *regexp.Regexp
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     *float64
+	exclusiveMaximum     *float64
+}
+
+// ParseSchema parses the given JSON schema.
+func ParseSchema(data string) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal([]byte(data), &schema); err != nil {
+		return nil, fmt.Errorf("validatingstorage: invalid schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// MustParseSchema is like ParseSchema but panics if the given JSON schema is invalid.
+func MustParseSchema(data string) *Schema {
+	schema, err := ParseSchema(data)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+// UnmarshalJSON implements json.Unmarshaler.UnmarshalJSON.
+func (s *Schema) UnmarshalJSON(data []byte) error {
+	var b bool
+	if err := json.Unmarshal(data, &b); err == nil {
+		*s = Schema{isFalse: !b}
+		return nil
+	}
+	var keywords map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keywords); err != nil {
+		return err
+	}
+	var unsupportedKeywords []string
+	for keyword := range keywords {
+		if _, ok := supportedKeywords[keyword]; !ok {
+			unsupportedKeywords = append(unsupportedKeywords, keyword)
+		}
+	}
+	if len(unsupportedKeywords) >= 1 {
+		sort.Strings(unsupportedKeywords)
+		return fmt.Errorf("unsupported keywords: %q", unsupportedKeywords)
+	}
+	var rawSchema struct {
+		Type                 json.RawMessage    `json:"type"`
+		Enum                 []interface{}      `json:"enum"`
+		Const                *json.RawMessage   `json:"const"`
+		Properties           map[string]*Schema `json:"properties"`
+		Required             []string           `json:"required"`
+		AdditionalProperties *Schema            `json:"additionalProperties"`
+		MinProperties        *int               `json:"minProperties"`
+		MaxProperties        *int               `json:"maxProperties"`
+		Items                *Schema            `json:"items"`
+		MinItems             *int               `json:"minItems"`
+		MaxItems             *int               `json:"maxItems"`
+		MinLength            *int               `json:"minLength"`
+		MaxLength            *int               `json:"maxLength"`
+		Pattern              *string            `json:"pattern"`
+		Minimum              *float64           `json:"minimum"`
+		Maximum              *float64           `json:"maximum"`
+		ExclusiveMinimum     *float64           `json:"exclusiveMinimum"`
+		ExclusiveMaximum     *float64           `json:"exclusiveMaximum"`
+	}
+	if err := json.Unmarshal(data, &rawSchema); err != nil {
+		return err
+	}
+	*s = Schema{
+		enum:                 rawSchema.Enum,
+		properties:           rawSchema.Properties,
+		required:             rawSchema.Required,
+		additionalProperties: rawSchema.AdditionalProperties,
+		minProperties:        rawSchema.MinProperties,
+		maxProperties:        rawSchema.MaxProperties,
+		items:                rawSchema.Items,
+		minItems:             rawSchema.MinItems,
+		maxItems:             rawSchema.MaxItems,
+		minLength:            rawSchema.MinLength,
+		maxLength:            rawSchema.MaxLength,
+		minimum:              rawSchema.Minimum,
+		maximum:              rawSchema.Maximum,
+		exclusiveMinimum:     rawSchema.ExclusiveMinimum,
+		exclusiveMaximum:     rawSchema.ExclusiveMaximum,
+	}
+	if len(rawSchema.Type) >= 1 {
+		var typ string
+		if err := json.Unmarshal(rawSchema.Type, &typ); err == nil {
+			s.types = []string{typ}
+		} else if err := json.Unmarshal(rawSchema.Type, &s.types); err != nil {
+			return fmt.Errorf("invalid type: %s", rawSchema.Type)
+		}
+	}
+	if rawSchema.Const != nil {
+		var constValue interface{}
+		if err := json.Unmarshal(*rawSchema.Const, &constValue); err != nil {
+			return err
+		}
+		s.constValue = &constValue
+	}
+	if rawSchema.Pattern != nil {
+		pattern, err := regexp.Compile(*rawSchema.Pattern)
+		if err != nil {
+			return err
+		}
+		s.pattern = pattern
+	}
+	return nil
+}
+
+var supportedKeywords = map[string]struct{}{
+	"type":                 {},
+	"enum":                 {},
+	"const":                {},
+	"properties":           {},
+	"required":             {},
+	"additionalProperties": {},
+	"minProperties":        {},
+	"maxProperties":        {},
+	"items":                {},
+	"minItems":             {},
+	"maxItems":             {},
+	"minLength":            {},
+	"maxLength":            {},
+	"pattern":              {},
+	"minimum":              {},
+	"maximum":              {},
+	"exclusiveMinimum":     {},
+	"exclusiveMaximum":     {},
+
+	"$schema":     {},
+	"$id":         {},
+	"$comment":    {},
+	"title":       {},
+	"description": {},
+	"default":     {},
+	"examples":    {},
+	"deprecated":  {},
+}
+
+// Validate validates the given JSON document, as decoded by encoding/json into an
+// interface{}, against the schema.
+func (s *Schema) Validate(document interface{}) error {
+	return s.validate(document, "")
+}
+
+func (s *Schema) validate(document interface{}, location string) error {
+	fail := func(format string, args ...interface{}) error {
+		if location == "" {
+			location = "/"
+		}
+		return fmt.Errorf("at %s: %s", location, fmt.Sprintf(format, args...))
+	}
+	if s.isFalse {
+		return fail("not allowed")
+	}
+	if len(s.types) >= 1 {
+		typ := typeOf(document)
+		ok := false
+		for _, typ2 := range s.types {
+			if typ2 == typ || (typ2 == "number" && typ == "integer") {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fail("expected type %s, got %s", strings.Join(s.types, " or "), typ)
+		}
+	}
+	if s.enum != nil {
+		ok := false
+		for _, value := range s.enum {
+			if reflect.DeepEqual(value, document) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fail("value not in enum")
+		}
+	}
+	if s.constValue != nil && !reflect.DeepEqual(*s.constValue, document) {
+		return fail("value not equal to const")
+	}
+	switch document := document.(type) {
+	case map[string]interface{}:
+		return s.validateObject(document, location, fail)
+	case []interface{}:
+		if s.minItems != nil && len(document) < *s.minItems {
+			return fail("too few items (%d < %d)", len(document), *s.minItems)
+		}
+		if s.maxItems != nil && len(document) > *s.maxItems {
+			return fail("too many items (%d > %d)", len(document), *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range document {
+				if err := s.items.validate(item, location+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(document)
+		if s.minLength != nil && n < *s.minLength {
+			return fail("string too short (%d < %d)", n, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fail("string too long (%d > %d)", n, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(document) {
+			return fail("string not matching %q", s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && document < *s.minimum {
+			return fail("number too small (%v < %v)", document, *s.minimum)
+		}
+		if s.maximum != nil && document > *s.maximum {
+			return fail("number too large (%v > %v)", document, *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && document <= *s.exclusiveMinimum {
+			return fail("number too small (%v <= %v)", document, *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && document >= *s.exclusiveMaximum {
+			return fail("number too large (%v >= %v)", document, *s.exclusiveMaximum)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateObject(document map[string]interface{}, location string,
+	fail func(string, ...interface{}) error) error {
+	if s.minProperties != nil && len(document) < *s.minProperties {
+		return fail("too few properties (%d < %d)", len(document), *s.minProperties)
+	}
+	if s.maxProperties != nil && len(document) > *s.maxProperties {
+		return fail("too many properties (%d > %d)", len(document), *s.maxProperties)
+	}
+	for _, name := range s.required {
+		if _, ok := document[name]; !ok {
+			return fail("missing required property %q", name)
+		}
+	}
+	names := make([]string, 0, len(document))
+	for name := range document {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		propertySchema, ok := s.properties[name]
+		if !ok {
+			propertySchema = s.additionalProperties
+		}
+		if propertySchema == nil {
+			continue
+		}
+		if err := propertySchema.validate(document[name], location+"/"+escapePointerToken(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func typeOf(document interface{}) string {
+	switch document := document.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if document == math.Trunc(document) && !math.IsInf(document, 0) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", document)
+	}
+}
+
+var pointerTokenEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointerToken(token string) string { return pointerTokenEscaper.Replace(token) }
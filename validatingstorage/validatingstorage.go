@@ -0,0 +1,148 @@
+// Package validatingstorage provides a decorator of versionedkv rejecting writes of
+// malformed keys and values.
+package validatingstorage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/internal/glob"
+)
+
+// Rule describes constraints on keys and values.
+type Rule struct {
+	// KeyPattern is the pattern of keys the rule applies to, in the syntax of path.Match,
+	// except that '*' matches any sequence of characters, including '/', as in aclstorage.
+	// The rule applies to all keys if empty.
+	KeyPattern string
+
+	// MaxKeyLength is the maximum length of keys in bytes.
+	// There is no limit if 0.
+	MaxKeyLength int
+
+	// KeyRegexp is the regular expression keys must match, e.g. `^[a-z0-9/_-]+$` to
+	// restrict the characters allowed.
+	// There is no constraint if nil.
+	KeyRegexp *regexp.Regexp
+
+	// MaxValueSize is the maximum size of values in bytes.
+	// There is no limit if 0.
+	MaxValueSize int
+
+	// Schema is the JSON schema values must conform to; values must be JSON documents if it
+	// is set.
+	// There is no constraint if nil.
+	Schema *Schema
+}
+
+// ValidationError is returned when a write is rejected.
+type ValidationError struct {
+	Key string
+
+	// RuleIndex is the index of the rule violated.
+	RuleIndex int
+
+	// Reason describes the violation.
+	Reason string
+}
+
+// Error implements error.Error.
+func (ve *ValidationError) Error() string {
+	return fmt.Sprintf("validatingstorage: validation failed: %s; key=%q ruleIndex=%d", ve.Reason, ve.Key, ve.RuleIndex)
+}
+
+// New creates a new storage validating writes to the given storage against the given rules.
+// A write must satisfy all the rules applying to its key.
+// It fails with an error wrapping ErrBadPattern if the key pattern of any rule is malformed.
+func New(storage versionedkv.Storage, rules []Rule) (versionedkv.Storage, error) {
+	for i := range rules {
+		if err := glob.Validate(rules[i].KeyPattern); err != nil {
+			return nil, fmt.Errorf("%w; ruleIndex=%d pattern=%q", ErrBadPattern, i, rules[i].KeyPattern)
+		}
+	}
+	return &validatingStorage{
+		Storage: storage,
+		rules:   append([]Rule(nil), rules...),
+	}, nil
+}
+
+type validatingStorage struct {
+	versionedkv.Storage
+
+	rules []Rule
+}
+
+func (vs *validatingStorage) CreateValue(ctx context.Context, key, val string) (versionedkv.Version, error) {
+	if err := vs.validate(key, val); err != nil {
+		return nil, err
+	}
+	return vs.Storage.CreateValue(ctx, key, val)
+}
+
+func (vs *validatingStorage) UpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := vs.validate(key, val); err != nil {
+		return nil, err
+	}
+	return vs.Storage.UpdateValue(ctx, key, val, oldVersion)
+}
+
+func (vs *validatingStorage) CreateOrUpdateValue(ctx context.Context, key, val string,
+	oldVersion versionedkv.Version) (versionedkv.Version, error) {
+	if err := vs.validate(key, val); err != nil {
+		return nil, err
+	}
+	return vs.Storage.CreateOrUpdateValue(ctx, key, val, oldVersion)
+}
+
+func (vs *validatingStorage) validate(key, val string) error {
+	for i := range vs.rules {
+		rule := &vs.rules[i]
+		if rule.KeyPattern != "" {
+			if ok, err := glob.Match(rule.KeyPattern, key); err == nil && !ok {
+				continue
+			}
+		}
+		if reason := rule.check(key, val); reason != "" {
+			return &ValidationError{
+				Key:       key,
+				RuleIndex: i,
+				Reason:    reason,
+			}
+		}
+	}
+	return nil
+}
+
+func (vs *validatingStorage) Capabilities() []versionedkv.Capability {
+	return versionedkv.CapabilitiesOf(vs.Storage)
+}
+
+func (r *Rule) check(key, val string) string {
+	if r.MaxKeyLength > 0 && len(key) > r.MaxKeyLength {
+		return fmt.Sprintf("key too long (%d > %d)", len(key), r.MaxKeyLength)
+	}
+	if r.KeyRegexp != nil && !r.KeyRegexp.MatchString(key) {
+		return fmt.Sprintf("key not matching %q", r.KeyRegexp.String())
+	}
+	if r.MaxValueSize > 0 && len(val) > r.MaxValueSize {
+		return fmt.Sprintf("value too large (%d > %d)", len(val), r.MaxValueSize)
+	}
+	if r.Schema != nil {
+		var document interface{}
+		if err := json.Unmarshal([]byte(val), &document); err != nil {
+			return fmt.Sprintf("value not JSON: %v", err)
+		}
+		if err := r.Schema.Validate(document); err != nil {
+			return err.Error()
+		}
+	}
+	return ""
+}
+
+// ErrBadPattern is returned when the key pattern of a rule is malformed.
+var ErrBadPattern error = errors.New("validatingstorage: bad pattern")
@@ -0,0 +1,160 @@
+package validatingstorage_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/go-tk/versionedkv"
+	"github.com/go-tk/versionedkv/memorystorage"
+	. "github.com/go-tk/versionedkv/validatingstorage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatingStorage(t *testing.T) {
+	versionedkv.DoTestStorage(t, func() (versionedkv.Storage, error) {
+		return New(memorystorage.New(), []Rule{{MaxKeyLength: 100, MaxValueSize: 1 << 20}})
+	})
+}
+
+func TestValidatingStorage_Rules(t *testing.T) {
+	t.Parallel()
+	s, err := New(memorystorage.New(), []Rule{
+		{
+			MaxKeyLength: 16,
+			KeyRegexp:    regexp.MustCompile(`^[a-z0-9/]+$`),
+			MaxValueSize: 64,
+		},
+		{
+			KeyPattern: "config/*",
+			Schema: MustParseSchema(`{
+				"type": "object",
+				"properties": {
+					"replicas": {"type": "integer", "minimum": 1},
+					"mode": {"enum": ["fast", "safe"]}
+				},
+				"required": ["replicas"],
+				"additionalProperties": false
+			}`),
+		},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	for _, tt := range []struct {
+		Key       string
+		Value     string
+		RuleIndex int
+		Reason    string
+	}{
+		{Key: "foo", Value: "bar"},
+		{Key: "config/a", Value: `{"replicas": 3, "mode": "safe"}`},
+		{Key: "config/a/b", Value: `{`, RuleIndex: 1, Reason: "value not JSON: unexpected end of JSON input"},
+		{Key: strings.Repeat("a", 17), Value: "bar", Reason: "key too long (17 > 16)"},
+		{Key: "Foo", Value: "bar", Reason: `key not matching "^[a-z0-9/]+$"`},
+		{Key: "foo", Value: strings.Repeat("a", 65), Reason: "value too large (65 > 64)"},
+		{Key: "config/b", Value: `{`, RuleIndex: 1, Reason: "value not JSON: unexpected end of JSON input"},
+		{Key: "config/b", Value: `{"mode": "fast"}`, RuleIndex: 1, Reason: `at /: missing required property "replicas"`},
+		{Key: "config/b", Value: `{"replicas": 0}`, RuleIndex: 1, Reason: "at /replicas: number too small (0 < 1)"},
+		{Key: "config/b", Value: `{"replicas": 1.5}`, RuleIndex: 1, Reason: "at /replicas: expected type integer, got number"},
+		{Key: "config/b", Value: `{"replicas": 1, "mode": "slow"}`, RuleIndex: 1, Reason: "at /mode: value not in enum"},
+		{Key: "config/b", Value: `{"replicas": 1, "x": 1}`, RuleIndex: 1, Reason: "at /x: not allowed"},
+	} {
+		for _, write := range []func() error{
+			func() error { _, err := s.CreateValue(ctx, tt.Key, tt.Value); return err },
+			func() error { _, err := s.UpdateValue(ctx, tt.Key, tt.Value, nil); return err },
+			func() error { _, err := s.CreateOrUpdateValue(ctx, tt.Key, tt.Value, nil); return err },
+		} {
+			err := write()
+			if tt.Reason == "" {
+				assert.NoError(t, err, tt.Key)
+				continue
+			}
+			var validationError *ValidationError
+			if !assert.True(t, errors.As(err, &validationError), tt.Key) {
+				continue
+			}
+			assert.Equal(t, ValidationError{Key: tt.Key, RuleIndex: tt.RuleIndex, Reason: tt.Reason}, *validationError)
+		}
+	}
+}
+
+func TestNew_BadPattern(t *testing.T) {
+	t.Parallel()
+	for _, pattern := range []string{"config/[", "config/[]", "config/\\"} {
+		_, err := New(memorystorage.New(), []Rule{{}, {KeyPattern: pattern, MaxValueSize: 1}})
+		assert.True(t, errors.Is(err, ErrBadPattern), pattern)
+	}
+}
+
+func TestSchema_Validate(t *testing.T) {
+	t.Parallel()
+	schema := MustParseSchema(`{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "maxLength": 5, "pattern": "^[a-z]+$"},
+			"tags": {"type": "array", "items": {"type": "string"}, "minItems": 1, "maxItems": 2},
+			"ratio": {"type": ["number", "null"], "exclusiveMinimum": 0, "maximum": 1},
+			"kind": {"const": "x"}
+		},
+		"additionalProperties": {"type": "boolean"},
+		"maxProperties": 5
+	}`)
+	for document, expectedErr := range map[string]string{
+		`{"name": "abc", "tags": ["a"], "ratio": null, "kind": "x", "flag": true}`: "",
+		`{"name": ""}`:                          "at /name: string too short (0 < 1)",
+		`{"name": "abcdef"}`:                    "at /name: string too long (6 > 5)",
+		`{"name": "ABC"}`:                       `at /name: string not matching "^[a-z]+$"`,
+		`{"tags": []}`:                          "at /tags: too few items (0 < 1)",
+		`{"tags": ["a", "b", "c"]}`:             "at /tags: too many items (3 > 2)",
+		`{"tags": [1]}`:                         "at /tags/0: expected type string, got integer",
+		`{"ratio": 0}`:                          "at /ratio: number too small (0 <= 0)",
+		`{"ratio": 2}`:                          "at /ratio: number too large (2 > 1)",
+		`{"ratio": "a"}`:                        "at /ratio: expected type number or null, got string",
+		`{"kind": "y"}`:                         "at /kind: value not equal to const",
+		`{"a/b": 1}`:                            "at /a~1b: expected type boolean, got integer",
+		`{"a":1,"b":1,"c":1,"d":1,"e":1,"f":1}`: "at /: too many properties (6 > 5)",
+		`[]`:                                    "at /: expected type object, got array",
+	} {
+		var value interface{}
+		if !assert.NoError(t, json.Unmarshal([]byte(document), &value)) {
+			continue
+		}
+		err := schema.Validate(value)
+		if expectedErr == "" {
+			assert.NoError(t, err, document)
+		} else {
+			assert.EqualError(t, err, expectedErr, document)
+		}
+	}
+
+	_, err := ParseSchema(`{"pattern": "("}`)
+	assert.Error(t, err)
+	for _, data := range []string{
+		`{"allOf": [{"type": "string"}]}`,
+		`{"oneOf": [{"type": "string"}, {"type": "number"}]}`,
+		`{"anyOf": [{"type": "string"}]}`,
+		`{"$ref": "#/definitions/foo"}`,
+		`{"not": {"type": "string"}}`,
+		`{"if": {"type": "string"}, "then": {"minLength": 1}}`,
+		`{"patternProperties": {"^a": {"type": "string"}}}`,
+		`{"format": "email"}`,
+		`{"properties": {"name": {"type": "string", "uniqueItems": true}}}`,
+		`{"items": {"contains": {"type": "string"}}}`,
+	} {
+		_, err := ParseSchema(data)
+		if assert.Error(t, err, data) {
+			assert.Contains(t, err.Error(), "unsupported keywords", data)
+		}
+	}
+	_, err = ParseSchema(`{"$schema": "http://json-schema.org/draft-07/schema#", "title": "T", "description": "D", "type": "string"}`)
+	assert.NoError(t, err)
+	assert.NoError(t, MustParseSchema(`true`).Validate(nil))
+	assert.Error(t, MustParseSchema(`false`).Validate(nil))
+}